@@ -0,0 +1,312 @@
+package webx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkdeng/goutil"
+)
+
+// loadCollections gathers the front matter of the child pages in each collection
+//
+// collections are listed in the app `config.yml`:
+//
+//	collections: [blog, changelog]
+//
+// each locale has its own lists, with the translations of the pages
+func (comp *compiler) loadCollections() {
+	collections := map[string]Data{}
+
+	for _, locale := range comp.siteLocales() {
+		collections[locale] = Data{}
+
+		for _, name := range comp.config.Collections {
+			name = strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+			if name == "" || name == "." {
+				continue
+			}
+
+			collections[locale]["collections."+strings.ReplaceAll(name, "/", ".")] = comp.loadCollection(locale, name)
+		}
+	}
+
+	comp.colMU.Lock()
+	comp.collections = collections
+	comp.colMU.Unlock()
+}
+
+// loadCollection returns the front matter of the child pages in a directory
+//
+// each page also has a {url}, {slug}, and default {title},
+// and the list is sorted by {date} (newest first)
+func (comp *compiler) loadCollection(locale string, name string) []Data {
+	list := comp.childPages(locale, strings.Split(name, "/"))
+	sortVars(list, "date", true)
+	return list
+}
+
+// childPages returns the front matter of the child pages of a directory, in a locale
+//
+// pages without a translation use the front matter of the default locale,
+// and their {url} has the prefix of the locale (see localeURL)
+func (comp *compiler) childPages(locale string, uriPath []string) []Data {
+	parentDirs := comp.pageDirs(strings.Split(strings.Trim(comp.localeURL(locale, uriPath), "/"), "/"))
+
+	names := []string{}
+	for _, dir := range parentDirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() && !goutil.Contains(names, file.Name()) {
+				names = append(names, file.Name())
+			}
+		}
+	}
+
+	list := []Data{}
+	for _, name := range names {
+		dirs := []string{}
+		for _, dir := range parentDirs {
+			if stat, err := os.Stat(dir + "/" + name); err == nil && stat.IsDir() {
+				dirs = append(dirs, dir+"/"+name)
+			}
+		}
+
+		if page, ok := comp.loadPageVars(dirs, locale, comp.localeURL(locale, append(uriPath[:len(uriPath):len(uriPath)], name))); ok {
+			list = append(list, page)
+		}
+	}
+
+	return list
+}
+
+// loadPageVars returns the front matter of a page, with its {url}, {slug}, and default {title}
+//
+// @dirs: the source directories of the page (see pageDirs). the head and body files are each read from the first directory that has one,
+// and translated files (i.e. body.es.md) are used before the regular files of a directory
+//
+// returns false if the page has no body file, or if the page is a draft, scheduled, or expired
+func (comp *compiler) loadPageVars(dirs []string, locale string, url string) (Data, bool) {
+	page := Data{}
+	hasBody := false
+
+	for _, part := range []string{"head", "body"} {
+		names := []string{part + ".html", part + ".md"}
+		if locale != "" {
+			names = append([]string{part + "." + locale + ".html", part + "." + locale + ".md"}, names...)
+		}
+
+		for _, dir := range dirs {
+			found := false
+			for _, name := range names {
+				if buf, err := os.ReadFile(dir + "/" + name); err == nil {
+					parseFrontMatter(&buf, page)
+					found = true
+					break
+				}
+			}
+
+			if found {
+				hasBody = hasBody || part == "body"
+				break
+			}
+		}
+	}
+
+	if !hasBody {
+		return nil, false
+	}
+
+	if visible, _ := comp.pageVisible(page, time.Now()); !visible {
+		return nil, false
+	}
+
+	slug := filepath.Base(url)
+	if url == "/" {
+		slug = ""
+	}
+
+	page["slug"] = slug
+	page["url"] = url
+	if _, ok := page["title"]; !ok {
+		page["title"] = capWords(slug)
+	}
+
+	return page, true
+}
+
+// collectionVars returns the {collections.name} vars of the locale of a page,
+// and the {collection} var for the index page of a collection
+func (comp *compiler) collectionVars(uriPath []string) Data {
+	locale, rest := comp.pageLocale(uriPath)
+
+	comp.colMU.RLock()
+	defer comp.colMU.RUnlock()
+
+	vars := Data{}
+	for key, val := range comp.collections[locale] {
+		vars[key] = val
+	}
+
+	if list, ok := comp.collections[locale]["collections."+strings.Join(rest, ".")]; ok && len(rest) != 0 {
+		vars["collection"] = list
+	}
+
+	return vars
+}
+
+// paginate splits the {collection} of an index page into pages, with `paginate: size` in its front matter
+//
+// @uriPath: the path of the page without its locale prefix (page urls are built with the prefix of the locale)
+//
+// returns the vars for each page, with the items of the page as {collection},
+// and the {pagination.page}, {pagination.pages}, {pagination.prev}, {pagination.next}, and {pagination.numbers} vars
+func (comp *compiler) paginate(locale string, uriPath []string, configVars Data) []Data {
+	size, err := strconv.Atoi(strings.TrimSpace(varString(configVars["paginate"])))
+	if err != nil || size <= 0 || len(uriPath) == 0 {
+		return nil
+	}
+
+	comp.colMU.RLock()
+	list, ok := comp.collections[locale]["collections."+strings.Join(uriPath, ".")].([]Data)
+	comp.colMU.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	pages := max(1, (len(list)+size-1)/size)
+
+	pageURL := func(page int) string {
+		if page < 1 || page > pages {
+			return ""
+		} else if page == 1 {
+			return comp.localeURL(locale, uriPath)
+		}
+		return comp.localeURL(locale, uriPath) + "/page/" + strconv.Itoa(page)
+	}
+
+	res := make([]Data, pages)
+	for i := range res {
+		page := i + 1
+
+		numbers := make([]Data, pages)
+		for j := range numbers {
+			numbers[j] = Data{"num": j + 1, "url": pageURL(j + 1), "current": j == i}
+		}
+
+		vars := Data{}
+		for key, val := range configVars {
+			vars[key] = val
+		}
+
+		vars["collection"] = list[min(i*size, len(list)):min(page*size, len(list))]
+		vars["pagination"] = Data{
+			"page":    page,
+			"pages":   pages,
+			"size":    size,
+			"total":   len(list),
+			"url":     pageURL(page),
+			"prev":    pageURL(page - 1),
+			"next":    pageURL(page + 1),
+			"first":   pageURL(1),
+			"last":    pageURL(pages),
+			"numbers": numbers,
+		}
+
+		res[i] = vars
+	}
+
+	return res
+}
+
+// inCollection returns true if a page path (relative to the pages directory) is part of a collection
+func (comp *compiler) inCollection(path string) bool {
+	// translations are listed in the collections of their locale
+	path = filepath.ToSlash(path)
+	if parts := strings.SplitN(path, "/", 2); len(parts) == 2 && comp.isLocale(parts[0]) {
+		path = parts[1]
+	}
+	for _, name := range comp.config.Collections {
+		name = strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+		if name != "" && name != "." && strings.HasPrefix(path+"/", name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortVars sorts a list of vars by a field (or by the items themselves if the field is empty)
+//
+// dates are compared as times, numbers as numbers, and everything else as text.
+// items without the field are moved to the end of the list
+func sortVars[T any](list []T, field string, desc bool) {
+	get := func(item any) (any, bool) {
+		if field == "" || field == "." {
+			return item, true
+		}
+		return varPath(item, field)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		a, okA := get(list[i])
+		b, okB := get(list[j])
+
+		if !okA || !okB {
+			return okA && !okB
+		}
+
+		if desc {
+			return compareVars(b, a) < 0
+		}
+		return compareVars(a, b) < 0
+	})
+}
+
+// compareVars compares two template vars
+func compareVars(a, b any) int {
+	if tA, ok := varTime(a); ok {
+		if tB, ok := varTime(b); ok {
+			return tA.Compare(tB)
+		}
+	}
+
+	if nA, ok := varNumber(a); ok {
+		if nB, ok := varNumber(b); ok {
+			if nA < nB {
+				return -1
+			} else if nA > nB {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(strings.ToLower(varString(a)), strings.ToLower(varString(b)))
+}
+
+// varNumber converts a number or a numeric string to a float64
+func varNumber(val any) (float64, bool) {
+	ref := reflect.ValueOf(val)
+	switch ref.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(ref.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(ref.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return ref.Float(), true
+	case reflect.String:
+		if n, err := strconv.ParseFloat(strings.TrimSpace(ref.String()), 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
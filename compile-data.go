@@ -0,0 +1,186 @@
+package webx
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tkdeng/goutil"
+	"gopkg.in/yaml.v3"
+)
+
+// loadData loads the `.yml`, `.json`, `.toml`, and `.csv` files in the data directory
+//
+// i.e. `data/team.yml` is available as {data.team}, and `data/pricing/plans.csv` as {data.pricing.plans}
+func (comp *compiler) loadData() {
+	data := Data{}
+
+	root := comp.config.Root + "/data"
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+
+		ext := filepath.Ext(rel)
+		keys := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, ext)), "/")
+
+		val, err := readDataFile(path)
+		if err != nil {
+			PrintMsg("error", "Data File Error: data/"+filepath.ToSlash(rel)+": "+err.Error(), 50, true)
+			return nil
+		} else if val == nil {
+			return nil
+		}
+
+		dir := data
+		for _, key := range keys[:len(keys)-1] {
+			if d, ok := dir[key].(Data); ok {
+				dir = d
+			} else {
+				dir[key] = Data{}
+				dir = dir[key].(Data)
+			}
+		}
+		dir[keys[len(keys)-1]] = val
+
+		return nil
+	})
+
+	comp.dataMU.Lock()
+	comp.data = data
+	comp.dataMU.Unlock()
+}
+
+// readDataFile parses a data file by its extension
+//
+// csv files return a list of rows, with the first row as the field names.
+// returns nil for other files
+func readDataFile(path string) (any, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var val any
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buf, &val)
+	case ".json":
+		err = json.Unmarshal(buf, &val)
+	case ".toml":
+		val, err = parseTOML(buf)
+	case ".csv":
+		var rows [][]string
+		rows, err = csv.NewReader(bytes.NewReader(bytes.TrimPrefix(buf, []byte("\ufeff")))).ReadAll()
+		if err != nil || len(rows) == 0 {
+			return []Data{}, err
+		}
+
+		list := make([]Data, len(rows)-1)
+		for i, row := range rows[1:] {
+			list[i] = Data{}
+			for j, field := range rows[0] {
+				if j < len(row) {
+					list[i][strings.TrimSpace(field)] = row[j]
+				}
+			}
+		}
+		val = list
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if val == nil {
+		val = Data{}
+	}
+	return val, nil
+}
+
+// dataVars provides the {data} vars to a page,
+// and records which data files the page uses so it can be rebuilt when they change
+type dataVars struct {
+	comp *compiler
+	page string
+}
+
+func (vars dataVars) get(name string) (any, bool) {
+	if name != "data" && !strings.HasPrefix(name, "data.") {
+		return nil, false
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(name, "data"), ".")
+	key, _, _ := strings.Cut(path, ".")
+
+	vars.comp.dataMU.Lock()
+	defer vars.comp.dataMU.Unlock()
+
+	if vars.comp.dataDeps == nil {
+		vars.comp.dataDeps = map[string]map[string]bool{}
+	}
+	if vars.comp.dataDeps[key] == nil {
+		vars.comp.dataDeps[key] = map[string]bool{}
+	}
+	vars.comp.dataDeps[key][vars.page] = true
+
+	if path == "" {
+		return vars.comp.data, vars.comp.data != nil
+	}
+	return varPath(vars.comp.data, path)
+}
+
+// compDataPages recompiles the pages that use a data file
+//
+// @key: the first part of the data path (i.e. `team` for {data.team.name})
+func (comp *compiler) compDataPages(key string) {
+	comp.dataMU.RLock()
+	pages := map[string]bool{}
+	for _, k := range []string{key, ""} {
+		for page := range comp.dataDeps[k] {
+			// generated pages (i.e. taxonomy terms) are compiled by their parent directory
+			for page != "" {
+				if dir, err := goutil.JoinPath(comp.config.Root+"/pages", page); err == nil {
+					if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+						break
+					}
+				}
+				page = filepath.ToSlash(filepath.Dir(page))
+				if page == "." {
+					page = ""
+				}
+			}
+			pages[page] = true
+		}
+	}
+	comp.dataMU.RUnlock()
+
+	if pages[""] {
+		comp.compPages()
+		return
+	}
+
+	for page := range pages {
+		// child pages are compiled with their parent directory
+		parent := false
+		for dir := filepath.Dir(page); dir != "." && !parent; dir = filepath.Dir(dir) {
+			parent = pages[dir]
+		}
+
+		if !parent {
+			comp.compPages(strings.Split(page, "/")...)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package webx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/tkdeng/goutil"
+)
+
+// tempCtx is the html context of a template variable
+//
+// states:
+//   - 't': element text
+//   - 'g': inside a tag (between attributes)
+//   - 'n': attribute name
+//   - 'm': after an attribute name
+//   - 'e': expecting an attribute value
+//   - 'a': quoted attribute value
+//   - 'u': unquoted attribute value
+//   - 's': inline <script>
+//   - 'c': inline <style>
+//   - '!': html comment
+type tempCtx struct {
+	state byte
+	quote byte
+
+	tag  string
+	attr string
+	val  []byte
+
+	// js is the state of the javascript code inside an inline <script>
+	js jsState
+
+	// next is the text after a var at the start of a url attribute, up to the end of its scheme
+	next []byte
+}
+
+var urlAttrs = []string{
+	"href", "src", "action", "formaction", "poster", "cite", "data", "background",
+	"longdesc", "manifest", "ping", "srcset", "icon", "codebase", "usemap", "xlink:href",
+}
+
+var safeURLSchemes = []string{"http", "https", "mailto", "tel"}
+
+// feed updates the html context with the next chunk of a template
+func (ctx *tempCtx) feed(buf []byte) {
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+
+		switch ctx.state {
+		case 0, 't':
+			if c != '<' {
+				continue
+			}
+
+			if bytes.HasPrefix(buf[i:], []byte("<!--")) {
+				ctx.state = '!'
+				i += 3
+			} else if i+1 < len(buf) && (isLetter(buf[i+1]) || buf[i+1] == '/') {
+				ctx.state = 'g'
+				ctx.tag = ""
+
+				j := i + 1
+				if buf[j] == '/' {
+					j++
+				}
+				for j < len(buf) && (isLetter(buf[j]) || (buf[j] >= '0' && buf[j] <= '9') || buf[j] == '-') {
+					j++
+				}
+
+				if buf[i+1] != '/' {
+					ctx.tag = strings.ToLower(string(buf[i+1 : j]))
+				}
+				i = j - 1
+			}
+		case 'g', 'm':
+			if c == '>' {
+				ctx.endTag()
+			} else if c == '=' && ctx.state == 'm' {
+				ctx.state = 'e'
+				ctx.val, ctx.next = nil, nil
+			} else if !isSpace(c) && c != '/' {
+				ctx.state = 'n'
+				ctx.attr = string(unicode.ToLower(rune(c)))
+				ctx.val, ctx.next = nil, nil
+			}
+		case 'n':
+			if c == '>' {
+				ctx.endTag()
+			} else if c == '=' {
+				ctx.state = 'e'
+				ctx.val, ctx.next = nil, nil
+			} else if isSpace(c) || c == '/' {
+				ctx.state = 'm'
+			} else {
+				ctx.attr += string(unicode.ToLower(rune(c)))
+			}
+		case 'e':
+			if c == '>' {
+				ctx.endTag()
+			} else if c == '"' || c == '\'' {
+				ctx.state = 'a'
+				ctx.quote = c
+				ctx.val = []byte{}
+			} else if !isSpace(c) {
+				ctx.state = 'u'
+				ctx.val = []byte{c}
+			}
+		case 'a':
+			if c == ctx.quote {
+				ctx.state = 'g'
+				ctx.attr = ""
+			} else {
+				ctx.val = append(ctx.val, c)
+			}
+		case 'u':
+			if c == '>' {
+				ctx.endTag()
+			} else if isSpace(c) {
+				ctx.state = 'g'
+				ctx.attr = ""
+			} else {
+				ctx.val = append(ctx.val, c)
+			}
+		case 's':
+			// the html parser ends the script even inside a string or comment
+			if c == '<' && hasPrefixFold(buf[i:], "</script") {
+				ctx.state = 't'
+				i--
+			} else {
+				i += ctx.js.feed(buf[i:])
+			}
+		case 'c':
+			if c == '<' && hasPrefixFold(buf[i:], "</style") {
+				ctx.state = 't'
+				i--
+			}
+		case '!':
+			if bytes.HasPrefix(buf[i:], []byte("-->")) {
+				ctx.state = 't'
+				i += 2
+			}
+		}
+	}
+}
+
+// clone returns a copy of the context that does not share its attribute value
+func (ctx tempCtx) clone() tempCtx {
+	ctx.val = append([]byte{}, ctx.val...)
+	ctx.js.tmpl = append([]int{}, ctx.js.tmpl...)
+	return ctx
+}
+
+func (ctx *tempCtx) endTag() {
+	ctx.attr = ""
+	ctx.js = jsState{}
+
+	switch ctx.tag {
+	case "script":
+		ctx.state = 's'
+	case "style":
+		ctx.state = 'c'
+	default:
+		ctx.state = 't'
+	}
+}
+
+// escapeVar escapes a variable for the html context it is embedded in
+func escapeVar(val any, ctx tempCtx) []byte {
+	str := varString(val)
+
+	if _, ok := val.(HTML); ok && (ctx.state == 't' || ctx.state == 0) {
+		return []byte(str)
+	}
+
+	switch ctx.state {
+	case 's':
+		if ctx.js.inText() {
+			return []byte(escapeJS(str))
+		}
+		return jsonValue(val)
+	case 'c':
+		return []byte(escapeCSS(str))
+	case 'g', 'n', 'm', 'e', 'a', 'u':
+		attr := ctx.attr
+		if ctx.state == 'g' || ctx.state == 'n' || ctx.state == 'm' {
+			attr = ""
+		}
+
+		if strings.HasPrefix(attr, "on") {
+			// javascript event handler
+			if js := scanJS(ctx.val); js.inText() {
+				str = escapeJS(str)
+			} else {
+				str = string(jsonValue(val))
+			}
+		} else if attr == "style" {
+			str = escapeCSS(str)
+		} else if attr == "srcdoc" {
+			// the value is the html of the iframe document, and is escaped again for the attribute
+			if _, ok := val.(HTML); !ok {
+				str = html.EscapeString(str)
+			}
+		} else if _, ok := val.(urlEncoded); !ok && goutil.Contains(urlAttrs, attr) {
+			str = escapeURL(str, ctx.val, ctx.next)
+		}
+
+		if ctx.state == 'a' {
+			return []byte(html.EscapeString(str))
+		}
+		return []byte(escapeUnquoted(str))
+	default:
+		return []byte(html.EscapeString(str))
+	}
+}
+
+// escapeURL blocks unsafe url schemes (like `javascript:`) in the url the var is part of,
+// and encodes the path or query if the var is in the middle of a url
+//
+// @prefix, @suffix: the text before and after the var in the attribute value
+func escapeURL(str string, prefix []byte, suffix []byte) string {
+	prefix = bytes.TrimLeftFunc(prefix, unicode.IsSpace)
+
+	// the scheme may be composed of text and vars (i.e. `java{x}` or `{x}:alert(1)`)
+	if !bytes.ContainsAny(prefix, ":/?#") {
+		s := strings.ToLower(string(prefix) + str + string(suffix))
+		if i := strings.IndexAny(s, ":/?#"); i != -1 && s[i] == ':' {
+			scheme := strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) || unicode.IsControl(r) {
+					return -1
+				}
+				return r
+			}, s[:i])
+
+			if !goutil.Contains(safeURLSchemes, scheme) {
+				return "#blocked"
+			}
+		}
+
+		if len(prefix) == 0 {
+			return str
+		}
+	}
+
+	if bytes.ContainsAny(prefix, "?#") {
+		return url.QueryEscape(str)
+	}
+	return url.PathEscape(str)
+}
+
+// escapeUnquoted encodes every non alphanumeric character for unquoted html attributes
+func escapeUnquoted(str string) string {
+	var b strings.Builder
+	for _, r := range str {
+		if r < 128 && (isLetter(byte(r)) || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.') {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "&#x%x;", r)
+		}
+	}
+	return b.String()
+}
+
+// escapeJS escapes a string for use inside a javascript string literal
+func escapeJS(str string) string {
+	var b strings.Builder
+	for _, r := range str {
+		switch r {
+		case '\\', '\'', '"', '`', '<', '>', '&', '=', '/', '\u2028', '\u2029':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// escapeCSS escapes a string for use inside css
+func escapeCSS(str string) string {
+	var b strings.Builder
+	for _, r := range str {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.,%#", r) {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, `\%x `, r)
+		}
+	}
+	return b.String()
+}
+
+// jsonValue encodes a var as a javascript value
+func jsonValue(val any) []byte {
+	b, err := json.Marshal(val)
+	if err != nil {
+		b, _ = json.Marshal(varString(val))
+	}
+	return b
+}
+
+// jsState tracks the strings, comments, and regex literals of javascript code,
+// so vars are only escaped as strings when they are inside one
+type jsState struct {
+	// quote is the open string quote, or '/' inside a regex literal
+	quote byte
+
+	// comment is '/' inside a line comment, or '*' inside a block comment
+	comment byte
+
+	// class is true inside a [character class] of a regex literal
+	class bool
+
+	// prev and word are the last character and identifier of the code, used to tell a regex from a division
+	prev byte
+	word string
+
+	// tmpl has the brace depths of the open ${} expressions in template strings
+	tmpl  []int
+	depth int
+}
+
+// jsRegexKeywords can be followed by a regex literal
+var jsRegexKeywords = []string{"return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await"}
+
+// feed updates the state with the next character of the code,
+// and returns the number of extra characters it used
+func (js *jsState) feed(buf []byte) int {
+	c := buf[0]
+	next := byte(0)
+	if len(buf) > 1 {
+		next = buf[1]
+	}
+
+	switch {
+	case js.comment == '/':
+		if c == '\n' || c == '\r' {
+			js.comment = 0
+		}
+	case js.comment == '*':
+		if c == '*' && next == '/' {
+			js.comment = 0
+			return 1
+		}
+	case js.quote == '/':
+		if c == '\\' {
+			return 1
+		} else if c == '[' {
+			js.class = true
+		} else if c == ']' {
+			js.class = false
+		} else if (c == '/' && !js.class) || c == '\n' {
+			js.quote = 0
+			js.prev = ')'
+		}
+	case js.quote == '`':
+		if c == '\\' {
+			return 1
+		} else if c == '`' {
+			js.quote = 0
+			js.prev = ')'
+		} else if c == '$' && next == '{' {
+			js.quote = 0
+			js.tmpl = append(js.tmpl, js.depth)
+			js.depth++
+			js.prev = '{'
+			return 1
+		}
+	case js.quote != 0:
+		if c == '\\' {
+			return 1
+		} else if c == js.quote || c == '\n' {
+			js.quote = 0
+			js.prev = ')'
+		}
+	case c == '/' && (next == '/' || next == '*'):
+		js.comment = next
+		return 1
+	case c == '/' && js.regexAllowed():
+		js.quote = '/'
+		js.class = false
+	case c == '"' || c == '\'' || c == '`':
+		js.quote = c
+	case c == '{':
+		js.depth++
+		js.prev = c
+	case c == '}':
+		js.depth--
+		if l := len(js.tmpl); l != 0 && js.tmpl[l-1] == js.depth {
+			js.tmpl = js.tmpl[:l-1]
+			js.quote = '`'
+		} else {
+			js.prev = c
+		}
+	case isIdentChar(c):
+		if !isIdentChar(js.prev) {
+			js.word = ""
+		}
+		js.word += string(c)
+		js.prev = c
+	case !isSpace(c):
+		js.prev = c
+	}
+	return 0
+}
+
+// regexAllowed returns true if a '/' starts a regex literal, rather than a division
+func (js *jsState) regexAllowed() bool {
+	if js.prev == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", js.prev) != -1 {
+		return true
+	}
+	return isIdentChar(js.prev) && goutil.Contains(jsRegexKeywords, js.word)
+}
+
+// inText returns true inside a string, comment, or regex literal
+func (js *jsState) inText() bool {
+	return js.quote != 0 || js.comment != 0
+}
+
+// scanJS returns the state of the code at the end of a javascript attribute value
+func scanJS(val []byte) jsState {
+	js := jsState{}
+	for i := 0; i < len(val); i++ {
+		i += js.feed(val[i:])
+	}
+	return js
+}
+
+func hasPrefixFold(buf []byte, prefix string) bool {
+	return len(buf) >= len(prefix) && strings.EqualFold(string(buf[:len(prefix)]), prefix)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isLetter(c) || (c >= '0' && c <= '9') || c == '_' || c == '$' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,306 @@
+package webx
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// feedLimit is the default number of entries in a feed
+var feedLimit = 20
+
+type feedEntry struct {
+	title   string
+	url     string
+	summary string
+	author  string
+	date    time.Time
+	content []byte
+}
+
+// feedEnabled returns true if a directory has `feed: yes` in its front matter, or is listed as a feed in the app `config.yml`
+//
+//	feeds: [blog, changelog]
+func (comp *compiler) feedEnabled(uriPath []string, configVars Data) bool {
+	if len(uriPath) == 0 {
+		return false
+	}
+
+	if val, ok := configVars["feed"]; ok {
+		return isTruthy(val)
+	}
+
+	for _, name := range comp.config.Feeds {
+		if strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/") == strings.Join(uriPath, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// compFeeds writes the `feed.xml` (Atom), `rss.xml`, and `feed.json` files of a directory
+//
+// each entry is a child page, with its title, summary, date, and rendered body.
+// the newest pages are listed first (up to `feedlimit: 20` in the front matter of the directory)
+func (comp *compiler) compFeeds(uriPath []string, configVars Data) {
+	dist, err := goutil.JoinPath(comp.config.Root+"/dist", uriPath...)
+	if err != nil {
+		return
+	}
+
+	// translations list the pages of their locale
+	locale, rest := comp.pageLocale(uriPath)
+
+	if !comp.feedEnabled(rest, configVars) {
+		comp.feedMU.Lock()
+		_, ok := comp.feeds[strings.Join(uriPath, "/")]
+		delete(comp.feeds, strings.Join(uriPath, "/"))
+		comp.feedMU.Unlock()
+
+		if ok {
+			os.Remove(dist + "/feed.xml")
+			os.Remove(dist + "/rss.xml")
+			os.Remove(dist + "/feed.json")
+		}
+		return
+	}
+
+	comp.feedMU.Lock()
+	if comp.feeds == nil {
+		comp.feeds = map[string]Data{}
+	}
+	comp.feeds[strings.Join(uriPath, "/")] = configVars
+	comp.feedMU.Unlock()
+
+	limit := feedLimit
+	if n, err := strconv.Atoi(varString(configVars["feedlimit"])); err == nil && n > 0 {
+		limit = n
+	}
+
+	pages := comp.childPages(locale, rest)
+	if len(pages) == 0 && len(comp.pageDirs(uriPath)) == 0 {
+		return
+	}
+
+	for _, page := range pages {
+		if _, ok := varTime(page["date"]); !ok {
+			if dirs := comp.pageDirs(append(uriPath[:len(uriPath):len(uriPath)], varString(page["slug"]))); len(dirs) != 0 {
+				if stat, err := os.Stat(dirs[0]); err == nil {
+					page["date"] = stat.ModTime()
+				}
+			}
+		}
+	}
+
+	sortVars(pages, "date", true)
+	pages = pages[:min(limit, len(pages))]
+
+	entries := make([]feedEntry, len(pages))
+	for i, page := range pages {
+		entries[i] = feedEntry{
+			title:   varString(page["title"]),
+			url:     varString(page["url"]),
+			summary: varString(page["summary"]),
+			author:  varString(page["author"]),
+		}
+		entries[i].date, _ = varTime(page["date"])
+
+		if entries[i].summary == "" {
+			entries[i].summary = varString(page["desc"])
+		}
+
+		// render the body of the page without its layout
+		path := append(uriPath[:len(uriPath):len(uriPath)], varString(page["slug"]))
+		buf := markLayoutIncludes([]byte("{@body}"), "layout")
+		vars := comp.compPage(&buf, path)
+		comp.compVars(&buf, path, false, vars)
+		entries[i].content = absoluteURLs(buf, comp.config.BaseURL)
+	}
+
+	baseURL := strings.TrimRight(comp.config.BaseURL, "/")
+	url := "/" + strings.Join(uriPath, "/")
+
+	title := varString(configVars["title"])
+	if title == "" && len(rest) != 0 {
+		title = capWords(rest[len(rest)-1]) + " | " + comp.config.Title
+	}
+
+	desc := varString(configVars["desc"])
+	if desc == "" {
+		desc = comp.config.Desc
+	}
+
+	// atom requires an author for entries without one
+	author := varString(configVars["author"])
+	if author == "" {
+		author = comp.config.Author
+	}
+	if author == "" {
+		author = comp.config.Title
+	}
+
+	hasAuthors := true
+	for _, entry := range entries {
+		hasAuthors = hasAuthors && entry.author != ""
+	}
+
+	updated := time.Now()
+	if len(entries) != 0 && !entries[0].date.IsZero() {
+		updated = entries[0].date
+	}
+
+	os.MkdirAll(dist, 0755)
+
+	// Atom
+	atom := regex.JoinBytes(
+		xml.Header, `<feed xmlns="http://www.w3.org/2005/Atom">`, '\n',
+		`<title>`, xmlEscape(title), `</title>`, '\n',
+		`<subtitle>`, xmlEscape(desc), `</subtitle>`, '\n',
+		`<link href="`, xmlEscape(baseURL+url), `"/>`, '\n',
+		`<link rel="self" href="`, xmlEscape(baseURL+url+"/feed.xml"), `"/>`, '\n',
+		`<id>`, xmlEscape(baseURL+url), `</id>`, '\n',
+		`<updated>`, updated.Format(time.RFC3339), `</updated>`, '\n',
+	)
+	if !hasAuthors {
+		atom = regex.JoinBytes(atom, `<author><name>`, xmlEscape(author), `</name></author>`, '\n')
+	}
+	for _, entry := range entries {
+		atom = regex.JoinBytes(atom,
+			`<entry>`,
+			`<title>`, xmlEscape(entry.title), `</title>`,
+			`<link href="`, xmlEscape(baseURL+entry.url), `"/>`,
+			`<id>`, xmlEscape(baseURL+entry.url), `</id>`,
+			`<updated>`, entry.date.Format(time.RFC3339), `</updated>`,
+		)
+		if entry.author != "" {
+			atom = regex.JoinBytes(atom, `<author><name>`, xmlEscape(entry.author), `</name></author>`)
+		}
+		if entry.summary != "" {
+			atom = regex.JoinBytes(atom, `<summary>`, xmlEscape(entry.summary), `</summary>`)
+		}
+		atom = regex.JoinBytes(atom, `<content type="html">`, xmlEscape(string(entry.content)), `</content>`, `</entry>`, '\n')
+	}
+	atom = append(atom, "</feed>\n"...)
+	os.WriteFile(dist+"/feed.xml", atom, 0755)
+
+	// RSS
+	rss := regex.JoinBytes(
+		xml.Header, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/">`, '\n',
+		`<channel>`, '\n',
+		`<title>`, xmlEscape(title), `</title>`, '\n',
+		`<link>`, xmlEscape(baseURL+url), `</link>`, '\n',
+		`<description>`, xmlEscape(desc), `</description>`, '\n',
+		`<atom:link href="`, xmlEscape(baseURL+url+"/rss.xml"), `" rel="self" type="application/rss+xml"/>`, '\n',
+		`<lastBuildDate>`, updated.Format(time.RFC1123Z), `</lastBuildDate>`, '\n',
+	)
+	for _, entry := range entries {
+		rss = regex.JoinBytes(rss,
+			`<item>`,
+			`<title>`, xmlEscape(entry.title), `</title>`,
+			`<link>`, xmlEscape(baseURL+entry.url), `</link>`,
+			`<guid>`, xmlEscape(baseURL+entry.url), `</guid>`,
+			`<pubDate>`, entry.date.Format(time.RFC1123Z), `</pubDate>`,
+		)
+		if entry.summary != "" {
+			rss = regex.JoinBytes(rss, `<description>`, xmlEscape(entry.summary), `</description>`)
+		}
+		rss = regex.JoinBytes(rss, `<content:encoded>`, xmlEscape(string(entry.content)), `</content:encoded>`, `</item>`, '\n')
+	}
+	rss = append(rss, "</channel>\n</rss>\n"...)
+	os.WriteFile(dist+"/rss.xml", rss, 0755)
+
+	// JSON Feed
+	type jsonAuthor struct {
+		Name string `json:"name"`
+	}
+
+	type jsonItem struct {
+		ID            string       `json:"id"`
+		URL           string       `json:"url"`
+		Title         string       `json:"title"`
+		Summary       string       `json:"summary,omitempty"`
+		ContentHTML   string       `json:"content_html"`
+		DatePublished string       `json:"date_published"`
+		Authors       []jsonAuthor `json:"authors,omitempty"`
+	}
+
+	items := make([]jsonItem, len(entries))
+	for i, entry := range entries {
+		items[i] = jsonItem{
+			ID:            baseURL + entry.url,
+			URL:           baseURL + entry.url,
+			Title:         entry.title,
+			Summary:       entry.summary,
+			ContentHTML:   string(entry.content),
+			DatePublished: entry.date.Format(time.RFC3339),
+		}
+		if entry.author != "" {
+			items[i].Authors = []jsonAuthor{{Name: entry.author}}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(struct {
+		Version     string     `json:"version"`
+		Title       string     `json:"title"`
+		HomePageURL string     `json:"home_page_url"`
+		FeedURL     string     `json:"feed_url"`
+		Description string     `json:"description,omitempty"`
+		Items       []jsonItem `json:"items"`
+	}{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       title,
+		HomePageURL: baseURL + url,
+		FeedURL:     baseURL + url + "/feed.json",
+		Description: desc,
+		Items:       items,
+	}); err == nil {
+		os.WriteFile(dist+"/feed.json", buf.Bytes(), 0755)
+	}
+}
+
+// absoluteURLs adds the base url to the root relative links and images of the content of a feed entry,
+// so feed readers do not resolve them against the url of the feed
+func absoluteURLs(buf []byte, baseURL string) []byte {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if baseURL == "" {
+		return buf
+	}
+
+	return regex.Comp(`(?i)(\s(?:href|src|poster|action)\s*=\s*["']?)/([^/])`).RepFunc(buf, func(data func(int) []byte) []byte {
+		return regex.JoinBytes(data(1), baseURL, '/', data(2))
+	})
+}
+
+// compParentFeeds writes the feeds that list a page again, after the page changes
+func (comp *compiler) compParentFeeds(path string) {
+	path = filepath.ToSlash(path)
+
+	comp.feedMU.RLock()
+	feeds := map[string]Data{}
+	for _, uriPath := range comp.localePaths(path) {
+		path := strings.Join(uriPath, "/")
+		for section, configVars := range comp.feeds {
+			if strings.HasPrefix(path+"/", section+"/") && path != section {
+				feeds[section] = configVars
+			}
+		}
+	}
+	comp.feedMU.RUnlock()
+
+	for section, configVars := range feeds {
+		comp.compFeeds(strings.Split(section, "/"), configVars)
+	}
+}
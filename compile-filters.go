@@ -0,0 +1,343 @@
+package webx
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tkdeng/regex"
+)
+
+// FilterFunc modifies a template variable
+//
+// i.e. {body | truncate 140 "..."} calls the "truncate" filter with the args ["140", "..."]
+type FilterFunc func(val any, args ...string) any
+
+// HTML is trusted html, and will not be escaped when embedded in html text
+type HTML string
+
+// urlEncoded is a url encoded string, and will not be encoded again in a url
+type urlEncoded string
+
+type tempFilter struct {
+	name string
+	args []string
+}
+
+var filterMU sync.RWMutex
+var filters = map[string]FilterFunc{
+	"upper": func(val any, args ...string) any {
+		return strings.ToUpper(varString(val))
+	},
+	"lower": func(val any, args ...string) any {
+		return strings.ToLower(varString(val))
+	},
+	"capitalize": func(val any, args ...string) any {
+		return capWords(varString(val))
+	},
+	"trim": func(val any, args ...string) any {
+		return strings.TrimSpace(varString(val))
+	},
+	"truncate": func(val any, args ...string) any {
+		str := varString(val)
+
+		size := 100
+		if len(args) > 0 {
+			if s, err := strconv.Atoi(args[0]); err == nil && s >= 0 {
+				size = s
+			}
+		}
+
+		suffix := "…"
+		if len(args) > 1 {
+			suffix = args[1]
+		}
+
+		if utf8.RuneCountInString(str) <= size {
+			return str
+		}
+		return strings.TrimSpace(string([]rune(str)[:size])) + suffix
+	},
+	"number": func(val any, args ...string) any {
+		num, err := strconv.ParseFloat(strings.TrimSpace(varString(val)), 64)
+		if err != nil {
+			return val
+		}
+
+		dec := 0
+		if len(args) > 0 {
+			if d, err := strconv.Atoi(args[0]); err == nil && d >= 0 {
+				dec = d
+			}
+		}
+
+		str := strconv.FormatFloat(math.Abs(num), 'f', dec, 64)
+		intPart, decPart, _ := strings.Cut(str, ".")
+
+		// add thousands separators
+		for i := len(intPart) - 3; i > 0; i -= 3 {
+			intPart = intPart[:i] + "," + intPart[i:]
+		}
+
+		if decPart != "" {
+			intPart += "." + decPart
+		}
+		if num < 0 {
+			intPart = "-" + intPart
+		}
+		return intPart
+	},
+	"date": func(val any, args ...string) any {
+		layout := "Jan 2, 2006"
+		if len(args) > 0 {
+			layout = args[0]
+		}
+
+		if t, ok := varTime(val); ok {
+			return t.Format(layout)
+		}
+		return val
+	},
+	"urlencode": func(val any, args ...string) any {
+		return urlEncoded(url.QueryEscape(varString(val)))
+	},
+	"json": func(val any, args ...string) any {
+		b, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	},
+	"default": func(val any, args ...string) any {
+		if !isTruthy(val) && len(args) > 0 {
+			return args[0]
+		}
+		return val
+	},
+	"length": func(val any, args ...string) any {
+		ref := reflect.ValueOf(val)
+		switch ref.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return ref.Len()
+		}
+		return utf8.RuneCountInString(varString(val))
+	},
+	"join": func(val any, args ...string) any {
+		sep := ", "
+		if len(args) > 0 {
+			sep = args[0]
+		}
+
+		ref := reflect.ValueOf(val)
+		if ref.Kind() != reflect.Slice && ref.Kind() != reflect.Array {
+			return val
+		}
+
+		list := make([]string, ref.Len())
+		for i := range list {
+			list[i] = varString(ref.Index(i).Interface())
+		}
+		return strings.Join(list, sep)
+	},
+	"sort": func(val any, args ...string) any {
+		list, ok := varList(val)
+		if !ok {
+			return val
+		}
+
+		field := ""
+		if len(args) > 0 {
+			field = args[0]
+		}
+
+		sortVars(list, field, len(args) > 1 && strings.EqualFold(args[1], "desc"))
+		return list
+	},
+	"reverse": func(val any, args ...string) any {
+		list, ok := varList(val)
+		if !ok {
+			return val
+		}
+
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+		return list
+	},
+	"where": func(val any, args ...string) any {
+		list, ok := varList(val)
+		if !ok || len(args) == 0 {
+			return val
+		}
+
+		res := []any{}
+		for _, item := range list {
+			field, ok := varPath(item, args[0])
+			if !ok {
+				continue
+			}
+
+			if len(args) == 1 {
+				if isTruthy(field) {
+					res = append(res, item)
+				}
+				continue
+			}
+
+			// match a value, or a value in a list (i.e. tags)
+			values, isList := varList(field)
+			if !isList {
+				values = []any{field}
+			}
+
+			for _, v := range values {
+				if strings.EqualFold(varString(v), args[1]) {
+					res = append(res, item)
+					break
+				}
+			}
+		}
+		return res
+	},
+	"limit": func(val any, args ...string) any {
+		list, ok := varList(val)
+		if !ok || len(args) == 0 {
+			return val
+		}
+
+		offset := 0
+		if len(args) > 1 {
+			if o, err := strconv.Atoi(args[1]); err == nil && o > 0 {
+				offset = min(o, len(list))
+			}
+		}
+		list = list[offset:]
+
+		if size, err := strconv.Atoi(args[0]); err == nil && size >= 0 && size < len(list) {
+			list = list[:size]
+		}
+		return list
+	},
+}
+
+// compFilters are the built in filters that use the config of the compiler
+//
+// @request: true if the template is rendered at request time (with vars that may come from users)
+var compFilters = map[string]func(comp *compiler, request bool, val any, args ...string) any{
+	"markdown": func(comp *compiler, request bool, val any, args ...string) any {
+		// markdown rendered at request time is sanitized, unless it is trusted explicitly
+		mode := TrustedMode
+		if request {
+			mode = SafeMode
+		}
+		if len(args) != 0 && args[0] == "safe" {
+			mode = SafeMode
+		} else if len(args) != 0 && args[0] == "trusted" {
+			mode = TrustedMode
+		}
+
+		buf := []byte(varString(val))
+		comp.compileMarkdown(&buf, mode)
+		return HTML(buf)
+	},
+}
+
+// NewFilter adds a template filter, or replaces an existing one
+//
+// filters can be used in templates with pipes: {title | myfilter "arg"}
+func NewFilter(name string, cb FilterFunc) {
+	filterMU.Lock()
+	defer filterMU.Unlock()
+
+	filters[name] = cb
+	delete(compFilters, name)
+}
+
+// parseFilters parses a list of filters
+//
+// i.e. ` | truncate 140 "..." | upper`
+func parseFilters(buf []byte) []tempFilter {
+	list := []tempFilter{}
+
+	for _, m := range regex.Comp(`\|\s*([\w_\-]+)((?:\s+(?:"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|[^\s"'\|\}]+))*)`).RE.FindAllSubmatch(buf, -1) {
+		filter := tempFilter{name: string(m[1]), args: []string{}}
+
+		for _, arg := range regex.Comp(`"((?:\\.|[^"\\])*)"|'((?:\\.|[^'\\])*)'|([^\s"'\|\}]+)`).RE.FindAllSubmatch(m[2], -1) {
+			filter.args = append(filter.args, string(regex.Comp(`\\(.)`).Rep(regex.JoinBytes(arg[1], arg[2], arg[3]), []byte("$1"))))
+		}
+
+		list = append(list, filter)
+	}
+
+	return list
+}
+
+// applyFilters runs a value through a list of filters
+//
+// unknown filters are ignored
+//
+// @request: true if the value is rendered at request time (see compFilters)
+func applyFilters(comp *compiler, request bool, val any, list []tempFilter) any {
+	if len(list) == 0 {
+		return val
+	}
+
+	if comp == nil {
+		comp = &compiler{}
+	}
+
+	filterMU.RLock()
+	defer filterMU.RUnlock()
+
+	for _, filter := range list {
+		if cb, ok := compFilters[filter.name]; ok {
+			val = cb(comp, request, val, filter.args...)
+		} else if cb, ok := filters[filter.name]; ok {
+			val = cb(val, filter.args...)
+		}
+	}
+
+	return val
+}
+
+// varList copies a slice or array to a new list
+func varList(val any) ([]any, bool) {
+	ref := reflect.ValueOf(val)
+	if ref.Kind() != reflect.Slice && ref.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	list := make([]any, ref.Len())
+	for i := range list {
+		list[i] = ref.Index(i).Interface()
+	}
+	return list, true
+}
+
+// varTime converts a time.Time or a date string to a time.Time
+func varTime(val any) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+		return time.Time{}, false
+	}
+
+	str := strings.TrimSpace(varString(val))
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
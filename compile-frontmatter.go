@@ -0,0 +1,110 @@
+package webx
+
+import (
+	"strings"
+
+	"github.com/tkdeng/regex"
+	"gopkg.in/yaml.v3"
+)
+
+// parseFrontMatter removes the yaml front matter from a file, and adds its vars to config
+//
+// values keep their yaml types (lists, objects, numbers, and booleans), and keys keep their case.
+// dates are kept as they are written.
+//
+// top level keys are also added in lowercase without `-` and `_` (i.e. `app_title` as {apptitle}), as older sites expect.
+// nested keys are kept as they are written
+func parseFrontMatter(buf *[]byte, config Data) {
+	*buf = regex.Comp(`(?s)^---\r?\n(.*?)\r?\n---\r?\n`).RepFunc(*buf, func(data func(int) []byte) []byte {
+		var node yaml.Node
+		if err := yaml.Unmarshal(data(1), &node); err != nil || len(node.Content) == 0 {
+			return []byte{}
+		}
+
+		vars, ok := yamlValue(node.Content[0]).(map[string]any)
+		if !ok {
+			return []byte{}
+		}
+
+		for key, val := range vars {
+			config[key] = val
+		}
+
+		for key, val := range vars {
+			if name := frontMatterKey(key); name != key {
+				if _, ok := vars[name]; !ok {
+					config[name] = val
+				}
+			}
+		}
+
+		return []byte{}
+	})
+}
+
+// yamlValue converts a yaml node to a value, and keeps the case of map keys
+func yamlValue(node *yaml.Node) any {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) != 0 {
+			return yamlValue(node.Content[0])
+		}
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.SequenceNode:
+		list := make([]any, len(node.Content))
+		for i, item := range node.Content {
+			list[i] = yamlValue(item)
+		}
+		return list
+	case yaml.MappingNode:
+		vars := map[string]any{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			// merge keys (<<: *base)
+			if node.Content[i].Tag == "!!merge" {
+				if base, ok := yamlValue(node.Content[i+1]).(map[string]any); ok {
+					for key, val := range base {
+						if _, ok := vars[key]; !ok {
+							vars[key] = val
+						}
+					}
+				}
+				continue
+			}
+
+			vars[node.Content[i].Value] = yamlValue(node.Content[i+1])
+		}
+		return vars
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!int", "!!float", "!!bool", "!!null":
+			var val any
+			if err := node.Decode(&val); err == nil {
+				return val
+			}
+		}
+		return node.Value
+	}
+	return nil
+}
+
+// frontMatterKey returns the lowercase name of a front matter key, without `-` and `_`
+func frontMatterKey(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
+
+// PageMeta returns the front matter of a page, with its `url` and `slug`
+//
+// drafts, and pages that are not published yet or have expired, are not found
+func (app *App) PageMeta(url string) (Data, bool) {
+	url = "/" + strings.Trim(url, "/")
+
+	// translations without their own page use the default locale
+	uriPath := strings.Split(strings.Trim(url, "/"), "/")
+	if uriPath[0] == "" {
+		uriPath = []string{}
+	}
+
+	locale, _ := app.compiler.pageLocale(uriPath)
+	return app.compiler.loadPageVars(app.compiler.pageDirs(uriPath), locale, url)
+}
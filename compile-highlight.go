@@ -0,0 +1,186 @@
+package webx
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/tkdeng/regex"
+)
+
+// hlToken is a highlighted piece of code
+type hlToken struct {
+	class string
+	text  string
+}
+
+// highlightCode splits code into tokens with css classes, with the lexers of chroma
+//
+// unknown languages return the code as a single token
+func highlightCode(lang string, code string) []hlToken {
+	lexer := lexers.Get(strings.ToLower(lang))
+	if lang == "" || lexer == nil {
+		return []hlToken{{text: code}}
+	}
+
+	iter, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return []hlToken{{text: code}}
+	}
+
+	tokens := []hlToken{}
+	for _, token := range iter.Tokens() {
+		class := hlClass(token.Type)
+
+		// join tokens with the same class, to keep the html small
+		if l := len(tokens); l != 0 && tokens[l-1].class == class {
+			tokens[l-1].text += token.Value
+		} else {
+			tokens = append(tokens, hlToken{class: class, text: token.Value})
+		}
+	}
+	return tokens
+}
+
+// hlClass returns the css class of a chroma token type
+//
+// the classes are the same for every language, so a theme only needs a few colors
+func hlClass(t chroma.TokenType) string {
+	switch {
+	case t == chroma.KeywordType, t == chroma.NameClass, t == chroma.NameBuiltinPseudo:
+		return "hl-type"
+	case t == chroma.KeywordConstant, t == chroma.LiteralStringBoolean:
+		return "hl-literal"
+	case t.InCategory(chroma.Keyword), t == chroma.OperatorWord, t == chroma.CommentPreproc:
+		return "hl-keyword"
+	case t == chroma.NameTag:
+		return "hl-tag"
+	case t == chroma.NameAttribute:
+		return "hl-attr"
+	case t.InSubCategory(chroma.NameFunction), t.InSubCategory(chroma.NameBuiltin), t == chroma.NameDecorator:
+		return "hl-function"
+	case t.InSubCategory(chroma.NameVariable):
+		return "hl-variable"
+	case t.InSubCategory(chroma.LiteralString):
+		return "hl-string"
+	case t.InSubCategory(chroma.LiteralNumber), t == chroma.LiteralDate:
+		return "hl-number"
+	case t.InCategory(chroma.Comment):
+		return "hl-comment"
+	}
+	return ""
+}
+
+// codeBlock renders a highlighted code block
+//
+// the info string of a code fence sets the language, and these options:
+//
+//	```go {3-5,8} linenos
+//
+// - `{3-5,8}`: highlight lines
+// - `linenos`: show line numbers (or `linenos=10` to start at line 10)
+// - `nocopy`: leave out the copy button
+//
+// classes are used instead of inline styles, so the `theme` can style them and the page will work with CSP
+func codeBlock(info string, code string) []byte {
+	lang, opts, _ := strings.Cut(strings.TrimSpace(info), " ")
+	if strings.HasPrefix(lang, "{") {
+		lang, opts = "", info
+	}
+
+	marked := map[int]bool{}
+	for _, m := range regex.Comp(`\{([^\}]*)\}`).RE.FindAllStringSubmatch(opts, -1) {
+		for _, part := range regex.Comp(`[\s,]+`).RE.Split(m[1], -1) {
+			from, to, isRange := strings.Cut(part, "-")
+			start, err := strconv.Atoi(from)
+			if err != nil {
+				continue
+			}
+
+			end := start
+			if isRange {
+				if end, err = strconv.Atoi(to); err != nil {
+					continue
+				}
+			}
+
+			for i := start; i <= end && i-start < 10000; i++ {
+				marked[i] = true
+			}
+		}
+	}
+	opts = regex.Comp(`\{[^\}]*\}`).RE.ReplaceAllString(opts, " ")
+
+	lineStart := 0
+	copyBtn := true
+	for _, opt := range strings.Fields(opts) {
+		name, val, _ := strings.Cut(opt, "=")
+		switch name {
+		case "linenos":
+			lineStart = 1
+			if n, err := strconv.Atoi(val); err == nil {
+				lineStart = n
+			}
+		case "nocopy":
+			copyBtn = false
+		}
+	}
+
+	class := "code"
+	if lineStart != 0 {
+		class += " linenos"
+	}
+
+	buf := regex.JoinBytes(`<pre class="`, class, `"`)
+	if lang != "" {
+		buf = regex.JoinBytes(buf, ` data-lang="`, html.EscapeString(lang), `"`)
+	}
+	buf = append(buf, '>')
+
+	if copyBtn {
+		buf = append(buf, `<button type="button" class="code-copy" aria-label="Copy code">Copy</button>`...)
+	}
+
+	if lang != "" {
+		buf = regex.JoinBytes(buf, `<code class="language-`, html.EscapeString(lang), `">`)
+	} else {
+		buf = append(buf, "<code>"...)
+	}
+
+	// split tokens into lines, so each line can be numbered and highlighted
+	line := 1
+	startLine := func() {
+		buf = append(buf, `<span class="line`...)
+		if marked[line] {
+			buf = append(buf, ` hl-line`...)
+		}
+		buf = append(buf, '"')
+		if lineStart != 0 {
+			buf = regex.JoinBytes(buf, ` data-line="`, lineStart+line-1, `"`)
+		}
+		buf = append(buf, '>')
+	}
+
+	startLine()
+	for _, token := range highlightCode(lang, strings.TrimSuffix(code, "\n")) {
+		for i, text := range strings.Split(token.text, "\n") {
+			if i != 0 {
+				buf = append(buf, "\n</span>"...)
+				line++
+				startLine()
+			}
+
+			if text == "" {
+				continue
+			} else if token.class == "" {
+				buf = append(buf, html.EscapeString(text)...)
+			} else {
+				buf = regex.JoinBytes(buf, `<span class="`, token.class, `">`, html.EscapeString(text), `</span>`)
+			}
+		}
+	}
+
+	return append(buf, "\n</span></code></pre>\n"...)
+}
@@ -0,0 +1,191 @@
+package webx
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// loadLayout returns the layout a page is wrapped in
+//
+// an empty name returns the default layout (`pages/layout.html`, or the built in layout),
+// and any other name returns `layouts/{name}.html`
+//
+// a layout can extend another layout with `layout: name` in its front matter,
+// and override the {+blocks} of its parent
+func (comp *compiler) loadLayout(name string, extended ...string) []byte {
+	if name == "default" {
+		name = ""
+	}
+
+	top := len(extended) == 0
+
+	if i := slices.Index(extended, name); i != -1 {
+		chain := []string{}
+		for _, n := range extended[i:] {
+			chain = append(chain, layoutFile(n))
+		}
+
+		comp.compileErr(&LayoutError{File: layoutFile(extended[len(extended)-1]), Chain: append(chain, layoutFile(name))})
+		return markLayoutIncludes(tempLayout, "layout")
+	}
+	extended = append(extended, name)
+
+	var buf []byte
+	if name == "" {
+		if b, err := os.ReadFile(comp.config.Root + "/pages/layout.html"); err == nil {
+			buf = markLayoutIncludes(b, "pages/layout.html")
+		} else {
+			buf = markLayoutIncludes(tempLayout, "layout")
+		}
+	} else if path, err := goutil.JoinPath(comp.config.Root+"/layouts", name+".html"); err == nil {
+		if b, err := os.ReadFile(path); err == nil {
+			buf = markLayoutIncludes(b, "layouts/"+name+".html")
+		}
+	}
+
+	if buf == nil {
+		comp.compileErr(&LayoutError{File: layoutFile(name)})
+
+		// use the default layout, unless it is the layout that extends the missing one
+		if goutil.Contains(extended, "") {
+			buf = markLayoutIncludes(tempLayout, "layout")
+		} else {
+			buf = comp.loadLayout("", extended...)
+		}
+	}
+
+	config := Data{}
+	parseFrontMatter(&buf, config)
+
+	if parent := varString(config["layout"]); parent != "" {
+		blocks := map[string][]byte{}
+		layoutBlocks(buf, func(name string, content []byte) {
+			if _, ok := blocks[name]; !ok {
+				blocks[name] = content
+			}
+		})
+
+		buf = fillLayoutBlocks(comp.loadLayout(parent, extended...), blocks, true)
+	}
+
+	if top {
+		buf = fillLayoutBlocks(buf, nil, false)
+	}
+
+	return buf
+}
+
+// LayoutError is a layout that could not be loaded
+type LayoutError struct {
+	// File is the layout file, relative to the app root
+	File string
+
+	// Chain is the list of layouts that extend each other, if the layout is recursive
+	Chain []string
+}
+
+func (err *LayoutError) Error() string {
+	if len(err.Chain) != 0 {
+		return err.File + ": layout cycle: " + strings.Join(err.Chain, " -> ")
+	}
+	return err.File + ": layout not found"
+}
+
+// layoutFile returns the file of a layout, relative to the app root
+func layoutFile(name string) string {
+	if name == "" {
+		return "pages/layout.html"
+	}
+	return "layouts/" + name + ".html"
+}
+
+// regLayoutMark matches the mark after an include from a layout, with its file and line
+var regLayoutMark = `(?:\x00([^\x00]*\x00[0-9]+)\x00)`
+
+// markLayoutIncludes marks the {@includes} of a layout file with the file and line they are in,
+// so compPage can tell them apart from the includes of the page, and report their errors
+//
+// @path: the layout file, relative to the app root
+func markLayoutIncludes(buf []byte, path string) []byte {
+	res := []byte{}
+	last := 0
+	for _, m := range regex.Comp(regIncludeTag).RE.FindAllIndex(buf, -1) {
+		line := bytes.Count(buf[:m[0]], []byte{'\n'}) + 1
+		res = regex.JoinBytes(res, buf[last:m[1]], '\x00', path, '\x00', line, '\x00')
+		last = m[1]
+	}
+	return append(res, buf[last:]...)
+}
+
+// layoutBlocks calls cb for every {+block} in a layout, including nested blocks
+func layoutBlocks(buf []byte, cb func(name string, content []byte)) {
+	for _, m := range regex.Comp(`\{\+([\w_\-\.]+)\}`).RE.FindAllSubmatchIndex(buf, -1) {
+		name := string(buf[m[2]:m[3]])
+		if end := layoutBlockEnd(buf[m[1]:], name); end != -1 {
+			cb(name, buf[m[1]:m[1]+end])
+		}
+	}
+}
+
+// fillLayoutBlocks replaces the content of {+blocks} with the content from a child layout
+//
+// @keep: keep the block tags, so the blocks can be overridden again by another layout
+func fillLayoutBlocks(buf []byte, blocks map[string][]byte, keep bool) []byte {
+	res := []byte{}
+
+	for {
+		m := regex.Comp(`\{\+([\w_\-\.]+)\}`).RE.FindSubmatchIndex(buf)
+		if m == nil {
+			break
+		}
+
+		name := string(buf[m[2]:m[3]])
+		end := layoutBlockEnd(buf[m[1]:], name)
+		if end == -1 {
+			res = append(res, buf[:m[1]]...)
+			buf = buf[m[1]:]
+			continue
+		}
+
+		content := buf[m[1] : m[1]+end]
+		if b, ok := blocks[name]; ok {
+			content = b
+		}
+		content = fillLayoutBlocks(content, blocks, keep)
+
+		res = append(res, buf[:m[0]]...)
+		if keep {
+			res = append(res, buf[m[0]:m[1]]...)
+			res = append(res, content...)
+			res = append(res, "{/"+name+"}"...)
+		} else {
+			res = append(res, content...)
+		}
+
+		buf = buf[m[1]+end+len(name)+3:]
+	}
+
+	return append(res, buf...)
+}
+
+// layoutBlockEnd returns the index of the closing {/name} tag of a block
+//
+// nested {?name}, {!name}, {*name}, and {+name} tags with the same name are skipped
+func layoutBlockEnd(buf []byte, name string) int {
+	depth := 0
+	for _, m := range regex.Comp(`\{([?!*+]|/)%1\}`, name).RE.FindAllSubmatchIndex(buf, -1) {
+		if !bytes.Equal(buf[m[2]:m[3]], []byte{'/'}) {
+			depth++
+		} else if depth == 0 {
+			return m[0]
+		} else {
+			depth--
+		}
+	}
+	return -1
+}
@@ -0,0 +1,196 @@
+package webx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tkdeng/goutil"
+)
+
+// defaultLocale returns the locale of pages without a locale prefix ("" if the site has one language)
+func (comp *compiler) defaultLocale() string {
+	if comp.config.DefaultLocale != "" {
+		return comp.config.DefaultLocale
+	} else if len(comp.config.Locales) != 0 {
+		return comp.config.Locales[0]
+	}
+	return ""
+}
+
+// siteLocales returns the locales of the site, with the default locale first ("" if the site has one language)
+func (comp *compiler) siteLocales() []string {
+	locales := []string{comp.defaultLocale()}
+	for _, locale := range comp.config.Locales {
+		if !goutil.Contains(locales, locale) {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// pageLocale returns the locale of a url path, and the path without the locale prefix
+//
+// pages of the default locale have no prefix (i.e. /about), and other locales do (i.e. /es/about)
+func (comp *compiler) pageLocale(uriPath []string) (string, []string) {
+	def := comp.defaultLocale()
+	if len(uriPath) != 0 && uriPath[0] != def && goutil.Contains(comp.config.Locales, uriPath[0]) {
+		return uriPath[0], uriPath[1:]
+	}
+	return def, uriPath
+}
+
+// isLocale returns true if a page directory name is the tree of a locale (i.e. `pages/es`)
+func (comp *compiler) isLocale(name string) bool {
+	return name != "" && (goutil.Contains(comp.config.Locales, name) || name == comp.config.DefaultLocale)
+}
+
+// pageDirs returns the source directories of a url path, in the order their files are used
+//
+// a page is read from its locale tree (`pages/es/about`), then the shared tree (`pages/about`),
+// then the default locale tree (`pages/en/about`), so missing translations fall back to the default locale
+func (comp *compiler) pageDirs(uriPath []string) []string {
+	locale, rest := comp.pageLocale(uriPath)
+
+	roots := []string{""}
+	if locale != "" {
+		roots = []string{locale, ""}
+		if def := comp.defaultLocale(); def != locale {
+			roots = append(roots, def)
+		}
+	}
+
+	dirs := []string{}
+	for _, root := range roots {
+		parts := rest
+		if root != "" {
+			parts = append([]string{root}, rest...)
+		}
+
+		if dir, err := goutil.JoinPath(comp.config.Root+"/pages", parts...); err == nil {
+			if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// localeURL returns the url of a page in a locale
+//
+// @uriPath: the path of the page, without a locale prefix
+func (comp *compiler) localeURL(locale string, uriPath []string) string {
+	if locale != comp.defaultLocale() {
+		uriPath = append([]string{locale}, uriPath...)
+	}
+	return "/" + strings.Join(uriPath, "/")
+}
+
+// hasTranslation returns true if a page has its own body in a locale,
+// rather than falling back to the default locale
+func (comp *compiler) hasTranslation(locale string, uriPath []string) bool {
+	files := []string{}
+	if dir, err := goutil.JoinPath(comp.config.Root+"/pages", append([]string{locale}, uriPath...)...); err == nil {
+		files = append(files, dir+"/body.html", dir+"/body.md")
+	}
+	if dir, err := goutil.JoinPath(comp.config.Root+"/pages", uriPath...); err == nil {
+		files = append(files, dir+"/body."+locale+".html", dir+"/body."+locale+".md")
+		if locale == comp.defaultLocale() {
+			files = append(files, dir+"/body.html", dir+"/body.md")
+		}
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// localeVars returns the {lang} and {translations} vars of a page
+//
+// translations lists the locales the page is translated to, with a {.lang}, {.url},
+// and {.current} (true for the locale of the page)
+func (comp *compiler) localeVars(uriPath []string) Data {
+	locale, rest := comp.pageLocale(uriPath)
+	if locale == "" {
+		return Data{"lang": "en", "translations": []Data{}}
+	}
+
+	translations := []Data{}
+	for _, lang := range comp.config.Locales {
+		if lang == locale || comp.hasTranslation(lang, rest) {
+			translations = append(translations, Data{
+				"lang":    lang,
+				"url":     comp.localeURL(lang, rest),
+				"current": lang == locale,
+			})
+		}
+	}
+
+	return Data{"lang": locale, "translations": translations}
+}
+
+// localePaths returns the url paths of the pages that use a source directory (relative to the pages directory)
+//
+// the default locale and shared trees are also used by the translations that fall back to them
+func (comp *compiler) localePaths(path string) [][]string {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/")
+	if parts[0] == "" || parts[0] == "." {
+		parts = []string{}
+	}
+
+	def := comp.defaultLocale()
+	if def == "" {
+		return [][]string{parts}
+	}
+
+	if len(parts) != 0 && comp.isLocale(parts[0]) && parts[0] != def {
+		return [][]string{parts}
+	} else if len(parts) != 0 && parts[0] == def {
+		parts = parts[1:]
+	}
+
+	paths := [][]string{parts}
+	for _, locale := range comp.config.Locales {
+		if locale != def {
+			paths = append(paths, append([]string{locale}, parts...))
+		}
+	}
+	return paths
+}
+
+// compLocalePages recompiles the pages that use a source directory, in each locale
+func (comp *compiler) compLocalePages(path string) {
+	for _, uriPath := range comp.localePaths(path) {
+		comp.compPages(uriPath...)
+	}
+}
+
+// distURL returns the url of the compiled page for a request
+//
+// urls with the default locale prefix (i.e. /en/about) use the page without it,
+// and so do urls of a locale that has no compiled page
+func (comp *compiler) distURL(url string) string {
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	if comp.defaultLocale() == "" || !comp.isLocale(parts[0]) {
+		return url
+	}
+
+	if parts[0] != comp.defaultLocale() {
+		path, err := goutil.JoinPath(comp.config.Root+"/dist", parts...)
+		if err != nil {
+			return url
+		}
+
+		cPath := filepath.Dir(path) + "/#" + filepath.Base(path)
+		for _, file := range []string{path + ".html", path + ".html.gz", cPath + ".html"} {
+			if _, err := os.Stat(file); err == nil {
+				return url
+			}
+		}
+	}
+
+	return "/" + strings.Join(parts[1:], "/")
+}
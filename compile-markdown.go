@@ -0,0 +1,229 @@
+package webx
+
+import (
+	"html"
+	"io"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/tkdeng/regex"
+)
+
+// regCallout matches the `[!NOTE]` marker at the start of a callout block, with an optional title
+var regCallout = `^\[!(?i:(note|tip|important|warning|caution))\][ \t]*([^\n]*)\n?`
+
+// MarkdownMode sets how much markdown content is trusted
+type MarkdownMode int
+
+const (
+	// TrustedMode is for markdown written by the site (pages, and data files)
+	//
+	// html is kept as written, and template tags can be used
+	TrustedMode MarkdownMode = iota
+
+	// SafeMode is for markdown written by users (comments, and support tickets)
+	//
+	// the html is sanitized, template tags are left as text,
+	// and ids are prefixed with `user-content-`
+	SafeMode
+)
+
+type callout struct {
+	kind  string
+	title string
+}
+
+// RenderMarkdown compiles markdown to html at request time
+//
+// @mode: use SafeMode for content that was not written by the site
+func (app *App) RenderMarkdown(src string, mode MarkdownMode) HTML {
+	buf := []byte(src)
+	app.compiler.compileMarkdown(&buf, mode)
+	return HTML(buf)
+}
+
+// mdConfig returns the markdown config of the compiler
+func (comp *compiler) mdConfig() MarkdownConfig {
+	if comp.config == nil {
+		return MarkdownConfig{}
+	}
+	return comp.config.Markdown
+}
+
+// extensions returns the parser extensions enabled in the markdown config
+func (md MarkdownConfig) extensions() parser.Extensions {
+	extensions := parser.CommonExtensions&^(parser.Tables|parser.DefinitionLists|parser.MathJax) | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
+
+	if md.Tables == nil || *md.Tables {
+		extensions |= parser.Tables
+	}
+	if md.DefinitionLists == nil || *md.DefinitionLists {
+		extensions |= parser.DefinitionLists
+	}
+	if md.Math == nil || *md.Math {
+		extensions |= parser.MathJax
+	}
+	if md.Footnotes {
+		extensions |= parser.Footnotes
+	}
+
+	return extensions
+}
+
+// headingPrefix returns the prefix of heading ids (default "h-")
+func (md MarkdownConfig) headingPrefix() string {
+	if md.HeadingPrefix != nil {
+		return *md.HeadingPrefix
+	}
+	return "h-"
+}
+
+// rendererOptions returns the html renderer options of the markdown config
+func (md MarkdownConfig) rendererOptions() mdhtml.RendererOptions {
+	opts := mdhtml.RendererOptions{
+		HeadingIDPrefix: md.headingPrefix(),
+	}
+
+	if md.Smartypants == nil || *md.Smartypants {
+		opts.Flags |= mdhtml.CommonFlags
+	}
+	if md.Footnotes {
+		opts.Flags |= mdhtml.FootnoteReturnLinks
+	}
+	if md.ExternalLinks != "self" {
+		opts.Flags |= mdhtml.HrefTargetBlank
+	}
+	if md.Nofollow {
+		opts.Flags |= mdhtml.NofollowLinks
+	}
+
+	return opts
+}
+
+// mdOffsetHeadings moves the headings of a markdown document down by a number of levels
+func mdOffsetHeadings(doc ast.Node, offset int) {
+	if offset == 0 {
+		return
+	}
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if heading, ok := node.(*ast.Heading); ok && entering {
+			heading.Level = max(1, min(6, heading.Level+offset))
+		}
+		return ast.GoToNext
+	})
+}
+
+// mdCallouts finds the block quotes that start with a `> [!NOTE]` marker, and removes the marker
+//
+// a marker in the middle of a block quote starts a new callout
+func mdCallouts(doc ast.Node) map[ast.Node]callout {
+	callouts := map[ast.Node]callout{}
+
+	quotes := []*ast.BlockQuote{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if quote, ok := node.(*ast.BlockQuote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.GoToNext
+	})
+
+	for _, quote := range quotes {
+		// split block quotes at each marker
+		children := quote.GetChildren()
+		parts := [][]ast.Node{}
+		for i, child := range children {
+			if i == 0 || calloutText(child) == nil || !regex.Comp(regCallout).Match(calloutText(child).Literal) {
+				if len(parts) == 0 {
+					parts = append(parts, []ast.Node{})
+				}
+				parts[len(parts)-1] = append(parts[len(parts)-1], child)
+				continue
+			}
+			parts = append(parts, []ast.Node{child})
+		}
+
+		nodes := []ast.Node{quote}
+		for i, part := range parts {
+			if i == 0 {
+				quote.SetChildren(part)
+			} else {
+				next := &ast.BlockQuote{}
+				next.SetChildren(part)
+				nodes = append(nodes, next)
+			}
+			for _, child := range part {
+				child.SetParent(nodes[len(nodes)-1])
+			}
+		}
+
+		if len(nodes) > 1 {
+			parent := quote.GetParent()
+			siblings := []ast.Node{}
+			for _, sibling := range parent.GetChildren() {
+				if sibling == quote {
+					for _, node := range nodes {
+						node.SetParent(parent)
+						siblings = append(siblings, node)
+					}
+				} else {
+					siblings = append(siblings, sibling)
+				}
+			}
+			parent.SetChildren(siblings)
+		}
+
+		for _, node := range nodes {
+			if len(node.GetChildren()) == 0 {
+				continue
+			}
+
+			para := node.GetChildren()[0]
+			text := calloutText(para)
+			if text == nil {
+				continue
+			}
+
+			m := regex.Comp(regCallout).RE.FindSubmatch(text.Literal)
+			if m == nil {
+				continue
+			}
+
+			c := callout{kind: strings.ToLower(string(m[1])), title: strings.TrimSpace(string(m[2]))}
+			if c.title == "" {
+				c.title = capWords(c.kind)
+			}
+			callouts[node] = c
+
+			text.Literal = text.Literal[len(m[0]):]
+			if len(text.Literal) == 0 && len(para.GetChildren()) == 1 {
+				ast.RemoveFromTree(para)
+			}
+		}
+	}
+
+	return callouts
+}
+
+// calloutText returns the first text of a paragraph
+func calloutText(node ast.Node) *ast.Text {
+	if _, ok := node.(*ast.Paragraph); !ok || len(node.GetChildren()) == 0 {
+		return nil
+	}
+
+	text, _ := node.GetChildren()[0].(*ast.Text)
+	return text
+}
+
+// writeCallout writes the start or end of a callout block
+func writeCallout(r *mdhtml.Renderer, w io.Writer, c callout, entering bool) {
+	if entering {
+		r.CR(w)
+		r.Outs(w, `<div class="callout callout-`+c.kind+`"><p class="callout-title">`+html.EscapeString(c.title)+`</p>`)
+	} else {
+		r.Outs(w, `</div>`)
+		r.CR(w)
+	}
+}
@@ -0,0 +1,820 @@
+package webx
+
+import (
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tkdeng/goutil"
+)
+
+// MathError is invalid LaTeX math found while compiling markdown pages
+type MathError struct {
+	// File is the markdown file the math is in, relative to the app root
+	File string
+
+	// Line is the line of the math in File (0 if unknown)
+	Line int
+
+	// Math is the LaTeX source of the math
+	Math string
+
+	// Display is true for $$block$$ math
+	Display bool
+
+	// Err is the reason the math is invalid
+	Err error
+}
+
+func (err *MathError) Error() string {
+	file := err.File
+	if err.Line != 0 {
+		file += ":" + strconv.Itoa(err.Line)
+	}
+
+	delim := "$"
+	if err.Display {
+		delim = "$$"
+	}
+	return file + ": invalid math " + delim + strings.Join(strings.Fields(err.Math), " ") + delim + ": " + err.Err.Error()
+}
+
+// mathIdents are the commands of math identifiers, like greek letters
+var mathIdents = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "ell": "ℓ", "hbar": "ℏ", "imath": "ı", "jmath": "ȷ",
+	"Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘", "emptyset": "∅", "varnothing": "∅", "top": "⊤",
+	"bot": "⊥", "angle": "∠", "triangle": "△",
+}
+
+// mathOps are the commands of math operators, relations, and arrows
+var mathOps = map[string]string{
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈", "equiv": "≡",
+	"sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫", "subset": "⊂", "supset": "⊃",
+	"subseteq": "⊆", "supseteq": "⊇", "in": "∈", "notin": "∉", "ni": "∋", "perp": "⊥", "parallel": "∥",
+	"mid": "∣", "models": "⊨", "vdash": "⊢", "prec": "≺", "succ": "≻", "preceq": "⪯", "succeq": "⪰",
+	"doteq": "≐", "asymp": "≍",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔", "Rightarrow": "⇒",
+	"Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸", "iff": "⟺", "mapsto": "↦",
+	"longmapsto": "⟼", "longrightarrow": "⟶", "longleftarrow": "⟵", "Longrightarrow": "⟹", "Longleftarrow": "⟸",
+	"uparrow": "↑", "downarrow": "↓", "Uparrow": "⇑", "Downarrow": "⇓", "hookrightarrow": "↪",
+	"hookleftarrow": "↩", "nearrow": "↗", "searrow": "↘", "leadsto": "⇝",
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆", "circ": "∘",
+	"bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙", "cup": "∪", "cap": "∩",
+	"setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨", "uplus": "⊎", "sqcup": "⊔",
+	"sqcap": "⊓", "diamond": "⋄",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬", "cdots": "⋯", "ldots": "…",
+	"dots": "…", "vdots": "⋮", "ddots": "⋱", "colon": ":", "prime": "′", "backslash": "\\", "therefore": "∴",
+	"because": "∵", "langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"vert": "|", "Vert": "‖", "lvert": "|", "rvert": "|", "lVert": "‖", "rVert": "‖", "lbrace": "{", "rbrace": "}",
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+	"bigodot": "⨀", "bigvee": "⋁", "bigwedge": "⋀", "bigsqcup": "⨆", "int": "∫", "iint": "∬", "iiint": "∭",
+	"oint": "∮", "bmod": "mod",
+}
+
+// mathLimitOps are the operators with limits above and below them in block math (\int has them on the side)
+var mathLimitOps = []string{"sum", "prod", "coprod", "bigcup", "bigcap", "bigoplus", "bigotimes", "bigodot", "bigvee", "bigwedge", "bigsqcup"}
+
+// mathFuncs are the commands of function names, like \sin
+var mathFuncs = map[string]string{
+	"arcsin": "arcsin", "arccos": "arccos", "arctan": "arctan", "arg": "arg", "cos": "cos", "cosh": "cosh",
+	"cot": "cot", "coth": "coth", "csc": "csc", "deg": "deg", "det": "det", "dim": "dim", "exp": "exp",
+	"gcd": "gcd", "hom": "hom", "inf": "inf", "ker": "ker", "lg": "lg", "lim": "lim", "liminf": "lim inf",
+	"limsup": "lim sup", "ln": "ln", "log": "log", "max": "max", "min": "min", "Pr": "Pr", "sec": "sec",
+	"sin": "sin", "sinh": "sinh", "sup": "sup", "tan": "tan", "tanh": "tanh",
+}
+
+// mathLimitFuncs are the functions with limits below them in block math
+var mathLimitFuncs = []string{"det", "gcd", "inf", "lim", "liminf", "limsup", "max", "min", "Pr", "sup"}
+
+// mathAccents are the commands of accents over (or under) an argument, and if they stretch over all of it
+var mathAccents = map[string]struct {
+	char    string
+	stretch bool
+	under   bool
+}{
+	"hat": {"^", false, false}, "widehat": {"^", true, false}, "tilde": {"~", false, false},
+	"widetilde": {"~", true, false}, "bar": {"¯", false, false}, "overline": {"‾", true, false},
+	"vec": {"→", false, false}, "overrightarrow": {"→", true, false}, "overleftarrow": {"←", true, false},
+	"dot": {"˙", false, false}, "ddot": {"¨", false, false}, "acute": {"´", false, false},
+	"grave": {"`", false, false}, "breve": {"˘", false, false}, "check": {"ˇ", false, false},
+	"overbrace": {"⏞", true, false}, "underline": {"_", true, true}, "underbrace": {"⏟", true, true},
+}
+
+// mathSpaces are the widths of spacing commands
+var mathSpaces = map[string]string{
+	`\,`: "0.1667em", `\thinspace`: "0.1667em", `\:`: "0.2222em", `\>`: "0.2222em", `\;`: "0.2778em",
+	`\!`: "-0.1667em", `\ `: "0.3333em", "~": "0.3333em", `\enspace`: "0.5em", `\quad`: "1em", `\qquad`: "2em",
+}
+
+// mathDelimSizes are the sizes of \big delimiters
+var mathDelimSizes = map[string]string{"big": "1.2em", "Big": "1.8em", "bigg": "2.4em", "Bigg": "3em"}
+
+// mathFonts are the math alphabets of font commands (like \mathbb)
+var mathFonts = map[string]string{
+	"mathbf": "bold", "mathrm": "normal", "mathit": "", "mathbb": "double-struck", "mathcal": "script",
+	"mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+	"boldsymbol": "bold-italic", "bm": "bold-italic",
+}
+
+// mathAlphabets are the first capital letter, small letter, and digit (0 if none) of the unicode math alphabets
+var mathAlphabets = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"bold-italic":   {0x1D468, 0x1D482, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// mathAlphabetHoles are the letters of the math alphabets that are in the letterlike symbols block instead
+var mathAlphabetHoles = map[string]map[rune]rune{
+	"script": {
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ',
+		'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
+
+// mathEnvs are the fences and column alignment of matrix environments
+var mathEnvs = map[string][3]string{
+	"matrix": {"", "", ""}, "pmatrix": {"(", ")", ""}, "bmatrix": {"[", "]", ""}, "Bmatrix": {"{", "}", ""},
+	"vmatrix": {"|", "|", ""}, "Vmatrix": {"‖", "‖", ""}, "cases": {"{", "", "left"}, "array": {"", "", ""},
+	"aligned": {"", "", "right left"}, "align": {"", "", "right left"}, "align*": {"", "", "right left"},
+	"split": {"", "", "right left"}, "gathered": {"", "", ""},
+}
+
+// mathAtom is a parsed part of math, before its sub and superscripts
+type mathAtom struct {
+	ml string
+
+	// limits is 1 for limits below and above the atom in block math, or 2 for limits that are always below and above
+	limits int
+
+	// after is added after the scripts (i.e. the invisible function application after \sin)
+	after string
+}
+
+type mathParser struct {
+	src     string
+	pos     int
+	display bool
+
+	// variant is the math alphabet of letters and digits (i.e. `bold` in \mathbf)
+	variant string
+}
+
+// latexMathML converts LaTeX math to MathML
+//
+// only the math subset of LaTeX used in docs is supported (see mathIdents and mathOps),
+// so other commands are reported as errors rather than guessed
+//
+// @display: true for $$block$$ math, false for $inline$ math
+func latexMathML(tex string, display bool) (string, error) {
+	p := &mathParser{src: tex, display: display}
+
+	row, _, err := p.parseRow()
+	if err != nil {
+		return "", err
+	}
+
+	attr := ""
+	if display {
+		attr = ` display="block"`
+	}
+
+	// braces are escaped so template vars are not rendered in the math
+	return strings.NewReplacer("{", "&#123;", "}", "&#125;").Replace(
+		`<math` + attr + `><semantics><mrow>` + strings.Join(row, "") + `</mrow>` +
+			`<annotation encoding="application/x-tex">` + html.EscapeString(strings.TrimSpace(tex)) + `</annotation></semantics></math>`,
+	), nil
+}
+
+// skipSpace skips spaces and % comments
+func (p *mathParser) skipSpace() {
+	for p.pos < len(p.src) {
+		if c := p.src[p.pos]; c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			p.pos++
+		} else if c == '%' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+}
+
+// peek returns the next token, without moving past it
+//
+// a token is a command (i.e. `\frac` or `\{`), or a single character
+func (p *mathParser) peek() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return ""
+	}
+
+	if p.src[p.pos] == '\\' {
+		i := p.pos + 1
+		for i < len(p.src) && (p.src[i] >= 'a' && p.src[i] <= 'z' || p.src[i] >= 'A' && p.src[i] <= 'Z') {
+			i++
+		}
+
+		// starred commands, like \operatorname*
+		if i > p.pos+1 && i < len(p.src) && p.src[i] == '*' && p.src[p.pos+1:i] == "operatorname" {
+			i++
+		} else if i == p.pos+1 && i < len(p.src) {
+			_, size := utf8.DecodeRuneInString(p.src[i:])
+			i += size
+		}
+		return p.src[p.pos:i]
+	}
+
+	_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	return p.src[p.pos : p.pos+size]
+}
+
+// next returns the next token, and moves past it
+func (p *mathParser) next() string {
+	tok := p.peek()
+	p.pos += len(tok)
+	return tok
+}
+
+// parseRow parses math until one of the end tokens, and returns the end token that was found ("" at the end of the math)
+func (p *mathParser) parseRow(ends ...string) ([]string, string, error) {
+	row := []string{}
+	for {
+		tok := p.peek()
+		if tok == "" || goutil.Contains(ends, tok) {
+			p.pos += len(tok)
+			return row, tok, nil
+		}
+
+		if tok == `\displaystyle` || tok == `\textstyle` {
+			p.next()
+			rest, end, err := p.parseRow(ends...)
+			if err != nil {
+				return nil, "", err
+			}
+			row = append(row, `<mstyle displaystyle="`+strconv.FormatBool(tok == `\displaystyle`)+`">`+strings.Join(rest, "")+`</mstyle>`)
+			return row, end, nil
+		}
+
+		atom, err := p.parseAtom()
+		if err != nil {
+			return nil, "", err
+		}
+
+		ml, err := p.parseScripts(atom)
+		if err != nil {
+			return nil, "", err
+		}
+		row = append(row, ml)
+	}
+}
+
+// parseArg parses the argument of a command or script: a {group}, a command, or a single character
+func (p *mathParser) parseArg(name string) (string, error) {
+	tok := p.peek()
+	switch {
+	case tok == "" || tok == "}" || tok == "&" || tok == `\\` || tok == "^" || tok == "_":
+		return "", errors.New("missing argument for " + name)
+	case len(tok) == 1 && tok[0] >= '0' && tok[0] <= '9':
+		p.next()
+		return `<mn>` + p.mathChar(rune(tok[0])) + `</mn>`, nil
+	}
+
+	atom, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	return atom.ml + atom.after, nil
+}
+
+// parseGroup parses a {group} argument, and returns it as a single element
+func (p *mathParser) parseGroup(name string) (string, error) {
+	if p.peek() != "{" {
+		return "", errors.New("missing argument for " + name)
+	}
+
+	atom, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	return atom.ml, nil
+}
+
+// parseText reads the raw text of a {group} argument, like in \text{...}
+func (p *mathParser) parseText(name string) (string, error) {
+	if p.peek() != "{" {
+		return "", errors.New("missing argument for " + name)
+	}
+	p.pos++
+
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				text := p.src[start:p.pos]
+				p.pos++
+
+				// unescape \$, \%, \{, and other symbols
+				text = mathTextEscapes.Replace(text)
+				return text, nil
+			}
+			depth--
+		}
+	}
+
+	return "", errors.New("missing }")
+}
+
+// mathTextEscapes unescapes the symbols in \text{...}
+var mathTextEscapes = strings.NewReplacer(`\$`, "$", `\%`, "%", `\{`, "{", `\}`, "}", `\&`, "&", `\#`, "#", `\_`, "_", `\ `, " ", "~", " ")
+
+// parseDelim parses the delimiter after \left, \right, or \big
+func (p *mathParser) parseDelim(name string) (string, error) {
+	tok := p.next()
+	switch tok {
+	case ".":
+		return "", nil
+	case "(", ")", "[", "]", "|", "/":
+		return tok, nil
+	case "<", `\langle`:
+		return "⟨", nil
+	case ">", `\rangle`:
+		return "⟩", nil
+	case `\{`:
+		return "{", nil
+	case `\}`:
+		return "}", nil
+	case `\|`:
+		return "‖", nil
+	case "":
+		return "", errors.New("missing delimiter after " + name)
+	}
+
+	if strings.HasPrefix(tok, `\`) && goutil.Contains([]string{
+		"lbrace", "rbrace", "lfloor", "rfloor", "lceil", "rceil", "vert", "Vert", "lvert", "rvert", "lVert", "rVert",
+		"backslash", "uparrow", "downarrow", "Uparrow", "Downarrow",
+	}, tok[1:]) {
+		return mathOps[tok[1:]], nil
+	}
+
+	return "", errors.New("invalid delimiter " + tok + " after " + name)
+}
+
+// mathChar returns a letter or digit in the math alphabet of the parser
+func (p *mathParser) mathChar(c rune) string {
+	if alphabet, ok := mathAlphabets[p.variant]; ok {
+		if r, ok := mathAlphabetHoles[p.variant][c]; ok {
+			return string(r)
+		}
+
+		switch {
+		case c >= 'A' && c <= 'Z':
+			return string(alphabet[0] + c - 'A')
+		case c >= 'a' && c <= 'z':
+			return string(alphabet[1] + c - 'a')
+		case c >= '0' && c <= '9' && alphabet[2] != 0:
+			return string(alphabet[2] + c - '0')
+		}
+	}
+	return html.EscapeString(string(c))
+}
+
+// mathIdent returns an <mi> element, with the math alphabet of the parser
+func (p *mathParser) mathIdent(text string) string {
+	if p.variant == "normal" {
+		return `<mi mathvariant="normal">` + text + `</mi>`
+	}
+	return `<mi>` + text + `</mi>`
+}
+
+// parseAtom parses the next part of the math, without its sub and superscripts
+func (p *mathParser) parseAtom() (mathAtom, error) {
+	tok := p.next()
+
+	switch tok {
+	case "{":
+		row, end, err := p.parseRow("}")
+		if err != nil {
+			return mathAtom{}, err
+		} else if end != "}" {
+			return mathAtom{}, errors.New("missing }")
+		}
+		return mathAtom{ml: `<mrow>` + strings.Join(row, "") + `</mrow>`}, nil
+	case "}", "&", `\\`:
+		return mathAtom{}, errors.New("unexpected " + tok)
+	case "^", "_":
+		// scripts without a base
+		p.pos -= len(tok)
+		return mathAtom{ml: `<mrow></mrow>`}, nil
+	case "-":
+		return mathAtom{ml: `<mo>−</mo>`}, nil
+	case "*":
+		return mathAtom{ml: `<mo>∗</mo>`}, nil
+	case "'":
+		return mathAtom{ml: `<mo>′</mo>`}, nil
+	}
+
+	if width, ok := mathSpaces[tok]; ok {
+		return mathAtom{ml: `<mspace width="` + width + `"></mspace>`}, nil
+	}
+
+	if !strings.HasPrefix(tok, `\`) {
+		c, _ := utf8.DecodeRuneInString(tok)
+		switch {
+		case c >= '0' && c <= '9':
+			// read the rest of the number (i.e. 3.14)
+			num := p.mathChar(c)
+			for p.pos < len(p.src) {
+				c := p.src[p.pos]
+				if c == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+					num += "."
+				} else if c >= '0' && c <= '9' {
+					num += p.mathChar(rune(c))
+				} else {
+					break
+				}
+				p.pos++
+			}
+			return mathAtom{ml: `<mn>` + num + `</mn>`}, nil
+		case unicode.IsLetter(c):
+			return mathAtom{ml: p.mathIdent(p.mathChar(c))}, nil
+		}
+		return mathAtom{ml: `<mo>` + html.EscapeString(tok) + `</mo>`}, nil
+	}
+
+	name := tok[1:]
+
+	if len(name) == 1 && !unicode.IsLetter(rune(name[0])) {
+		switch name {
+		case "{", "}", "%", "$", "&", "#", "_":
+			return mathAtom{ml: `<mo>` + html.EscapeString(name) + `</mo>`}, nil
+		case "|":
+			return mathAtom{ml: `<mo>‖</mo>`}, nil
+		}
+		return mathAtom{}, errors.New("unknown command " + tok)
+	}
+
+	if ident, ok := mathIdents[name]; ok {
+		if r, _ := utf8.DecodeRuneInString(ident); unicode.IsUpper(r) {
+			return mathAtom{ml: `<mi mathvariant="normal">` + ident + `</mi>`}, nil
+		}
+		return mathAtom{ml: `<mi>` + ident + `</mi>`}, nil
+	}
+
+	if op, ok := mathOps[name]; ok {
+		atom := mathAtom{ml: `<mo>` + html.EscapeString(op) + `</mo>`}
+		if goutil.Contains(mathLimitOps, name) {
+			atom.limits = 1
+		}
+		return atom, nil
+	}
+
+	if fn, ok := mathFuncs[name]; ok {
+		atom := mathAtom{ml: `<mi>` + fn + `</mi>`, after: `<mo>&#x2061;</mo>`}
+		if goutil.Contains(mathLimitFuncs, name) {
+			atom.limits = 1
+		}
+		return atom, nil
+	}
+
+	if accent, ok := mathAccents[name]; ok {
+		arg, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		mo := `<mo stretchy="` + strconv.FormatBool(accent.stretch) + `">` + html.EscapeString(accent.char) + `</mo>`
+		if accent.under {
+			return mathAtom{ml: `<munder accentunder="true">` + arg + mo + `</munder>`, limits: 2}, nil
+		}
+
+		atom := mathAtom{ml: `<mover accent="true">` + arg + mo + `</mover>`}
+		if name == "overbrace" {
+			atom.limits = 2
+		}
+		return atom, nil
+	}
+
+	if variant, ok := mathFonts[name]; ok {
+		prev := p.variant
+		p.variant = variant
+		arg, err := p.parseArg(tok)
+		p.variant = prev
+		return mathAtom{ml: arg}, err
+	}
+
+	if size, ok := mathDelimSizes[strings.TrimRight(name, "lrm")]; ok {
+		delim, err := p.parseDelim(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+		return mathAtom{ml: `<mo minsize="` + size + `" maxsize="` + size + `">` + html.EscapeString(delim) + `</mo>`}, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom":
+		num, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+		den, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		switch name {
+		case "binom":
+			return mathAtom{ml: `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`}, nil
+		case "dfrac", "cfrac":
+			return mathAtom{ml: `<mstyle displaystyle="true"><mfrac>` + num + den + `</mfrac></mstyle>`}, nil
+		case "tfrac":
+			return mathAtom{ml: `<mstyle displaystyle="false"><mfrac>` + num + den + `</mfrac></mstyle>`}, nil
+		}
+		return mathAtom{ml: `<mfrac>` + num + den + `</mfrac>`}, nil
+	case "sqrt":
+		index := ""
+		if p.peek() == "[" {
+			p.next()
+			row, end, err := p.parseRow("]")
+			if err != nil {
+				return mathAtom{}, err
+			} else if end != "]" {
+				return mathAtom{}, errors.New("missing ] after " + tok)
+			}
+			index = `<mrow>` + strings.Join(row, "") + `</mrow>`
+		}
+
+		arg, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		if index != "" {
+			return mathAtom{ml: `<mroot>` + arg + index + `</mroot>`}, nil
+		}
+		return mathAtom{ml: `<msqrt>` + arg + `</msqrt>`}, nil
+	case "text", "textrm", "mbox", "textbf", "textit", "textsf", "texttt":
+		text, err := p.parseText(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		attr := ""
+		switch name {
+		case "textbf":
+			attr = ` mathvariant="bold"`
+		case "textit":
+			attr = ` mathvariant="italic"`
+		case "textsf":
+			attr = ` mathvariant="sans-serif"`
+		case "texttt":
+			attr = ` mathvariant="monospace"`
+		}
+		return mathAtom{ml: `<mtext` + attr + `>` + html.EscapeString(text) + `</mtext>`}, nil
+	case "operatorname", "operatorname*":
+		text, err := p.parseText(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		atom := mathAtom{ml: `<mi>` + html.EscapeString(text) + `</mi>`, after: `<mo>&#x2061;</mo>`}
+		if name == "operatorname*" {
+			atom.limits = 1
+		}
+		return atom, nil
+	case "pmod":
+		arg, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+		return mathAtom{ml: `<mrow><mspace width="1em"></mspace><mo>(</mo><mi>mod</mi><mspace width="0.3333em"></mspace>` + arg + `<mo>)</mo></mrow>`}, nil
+	case "not":
+		arg, err := p.parseArg(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		// add a combining slash to the symbol
+		if strings.HasSuffix(arg, `</mo>`) || strings.HasSuffix(arg, `</mi>`) {
+			arg = arg[:len(arg)-5] + "\u0338" + arg[len(arg)-5:]
+		}
+		return mathAtom{ml: arg}, nil
+	case "left":
+		left, err := p.parseDelim(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		row, end, err := p.parseRow(`\right`)
+		if err != nil {
+			return mathAtom{}, err
+		} else if end != `\right` {
+			return mathAtom{}, errors.New(`missing \right`)
+		}
+
+		right, err := p.parseDelim(`\right`)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		return mathAtom{ml: `<mrow>` + mathFence(left, "prefix") + strings.Join(row, "") + mathFence(right, "postfix") + `</mrow>`}, nil
+	case "middle":
+		delim, err := p.parseDelim(tok)
+		if err != nil {
+			return mathAtom{}, err
+		}
+		return mathAtom{ml: mathFence(delim, "infix")}, nil
+	case "right":
+		return mathAtom{}, errors.New(`\right without \left`)
+	case "end":
+		return mathAtom{}, errors.New(`\end without \begin`)
+	case "begin":
+		return p.parseEnv()
+	}
+
+	return mathAtom{}, errors.New("unknown command " + tok)
+}
+
+// mathFence returns a stretchy delimiter of \left and \right
+func mathFence(delim string, form string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo fence="true" form="` + form + `" stretchy="true">` + html.EscapeString(delim) + `</mo>`
+}
+
+// parseEnv parses a \begin{matrix} environment as a table
+func (p *mathParser) parseEnv() (mathAtom, error) {
+	name, err := p.parseText(`\begin`)
+	if err != nil {
+		return mathAtom{}, err
+	}
+
+	env, ok := mathEnvs[name]
+	if !ok {
+		return mathAtom{}, errors.New("unknown environment " + name)
+	}
+
+	align := env[2]
+	if name == "array" {
+		spec, err := p.parseText(`\begin{array}`)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		cols := []string{}
+		for _, c := range spec {
+			switch c {
+			case 'l':
+				cols = append(cols, "left")
+			case 'c':
+				cols = append(cols, "center")
+			case 'r':
+				cols = append(cols, "right")
+			}
+		}
+		align = strings.Join(cols, " ")
+	}
+
+	rows := [][]string{{}}
+	for {
+		cell, end, err := p.parseRow("&", `\\`, `\end`)
+		if err != nil {
+			return mathAtom{}, err
+		}
+
+		rows[len(rows)-1] = append(rows[len(rows)-1], `<mtd>`+strings.Join(cell, "")+`</mtd>`)
+
+		if end == "&" {
+			continue
+		} else if end == `\\` {
+			rows = append(rows, []string{})
+			continue
+		} else if end == "" {
+			return mathAtom{}, errors.New(`missing \end{` + name + `}`)
+		}
+
+		endName, err := p.parseText(`\end`)
+		if err != nil {
+			return mathAtom{}, err
+		} else if endName != name {
+			return mathAtom{}, errors.New(`\begin{` + name + `} ended by \end{` + endName + `}`)
+		}
+		break
+	}
+
+	// a \\ at the end of the last row does not add an empty row
+	if last := rows[len(rows)-1]; len(rows) > 1 && len(last) == 1 && last[0] == `<mtd></mtd>` {
+		rows = rows[:len(rows)-1]
+	}
+
+	ml := `<mtable`
+	if align != "" {
+		ml += ` columnalign="` + align + `"`
+	}
+	ml += `>`
+	for _, row := range rows {
+		ml += `<mtr>` + strings.Join(row, "") + `</mtr>`
+	}
+	ml += `</mtable>`
+
+	if env[0] != "" || env[1] != "" {
+		ml = `<mrow>` + mathFence(env[0], "prefix") + ml + mathFence(env[1], "postfix") + `</mrow>`
+	}
+	return mathAtom{ml: ml}, nil
+}
+
+// parseScripts parses the sub and superscripts after an atom
+func (p *mathParser) parseScripts(atom mathAtom) (string, error) {
+	sub, sup := "", ""
+
+	for {
+		tok := p.peek()
+		if tok == `\limits` {
+			p.next()
+			atom.limits = 2
+			continue
+		} else if tok == `\nolimits` {
+			p.next()
+			atom.limits = 0
+			continue
+		} else if tok != "^" && tok != "_" {
+			break
+		}
+		p.next()
+
+		arg, err := p.parseArg(tok)
+		if err != nil {
+			return "", err
+		}
+
+		if tok == "^" {
+			if sup != "" {
+				return "", errors.New("double superscript")
+			}
+			sup = arg
+		} else {
+			if sub != "" {
+				return "", errors.New("double subscript")
+			}
+			sub = arg
+		}
+	}
+
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if atom.limits == 2 || (atom.limits == 1 && p.display) {
+		under, over, both = "munder", "mover", "munderover"
+	}
+
+	ml := atom.ml
+	if sub != "" && sup != "" {
+		ml = `<` + both + `>` + ml + sub + sup + `</` + both + `>`
+	} else if sub != "" {
+		ml = `<` + under + `>` + ml + sub + `</` + under + `>`
+	} else if sup != "" {
+		ml = `<` + over + `>` + ml + sup + `</` + over + `>`
+	}
+
+	return ml + atom.after, nil
+}
+
+// renderMath converts LaTeX math to MathML
+//
+// invalid math is returned as an <merror> element with the LaTeX source, and the error
+func renderMath(tex string, display bool) ([]byte, error) {
+	ml, err := latexMathML(tex, display)
+	if err == nil {
+		return []byte(ml), nil
+	}
+
+	attr, delim := "", "$"
+	if display {
+		attr, delim = ` display="block"`, "$$"
+	}
+
+	return []byte(strings.NewReplacer("{", "&#123;", "}", "&#125;").Replace(
+		`<math` + attr + `><merror><mtext>` + html.EscapeString(delim+strings.TrimSpace(tex)+delim) + `</mtext></merror></math>`,
+	)), err
+}
@@ -0,0 +1,203 @@
+package webx
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// DataSource registers a Go function that lists the records of generated pages
+//
+// a `pages/products/[slug].html` page uses the `products` source by default,
+// or the source set with `source: name` in its front matter.
+// the pages using the source are compiled again when it is registered
+//
+// @cb: returns the records, with the fields of each record available as variables
+func (app *App) DataSource(name string, cb func() ([]Data, error)) {
+	comp := app.compiler
+
+	comp.srcMU.Lock()
+	if comp.sources == nil {
+		comp.sources = map[string]func() ([]Data, error){}
+	}
+	comp.sources[name] = cb
+	comp.srcMU.Unlock()
+
+	key, _, _ := strings.Cut(name, ".")
+	comp.compDataPages(key)
+}
+
+// regParamPage matches the `[param].html` template of generated pages
+var regParamPage = `^\[([\w_\-]+)\]\.(html|md)$`
+
+// compParamPages compiles a page for each record of a data source,
+// using the `[param].html` (or `[param].md`) template in a page directory
+//
+// the url of each page is the `param` field of its record (i.e. `[slug].html` uses {slug}).
+// in the directory of a taxonomy, `[term].html` compiles a page for each term, with the {term} var
+func (comp *compiler) compParamPages(dir, dist string, uriPath []string) {
+	// remove pages from the previous compile
+	comp.srcMU.Lock()
+	for _, page := range comp.genPages[dist] {
+		comp.removePage(page)
+	}
+	delete(comp.genPages, dist)
+	comp.srcMU.Unlock()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	path, param := "", ""
+	for _, file := range files {
+		if m := regex.Comp(regParamPage).RE.FindStringSubmatch(file.Name()); m != nil && !file.IsDir() {
+			path, param = dir+"/"+file.Name(), m[1]
+			break
+		}
+	}
+
+	if path == "" {
+		return
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	pageVars := Data{}
+	parseFrontMatter(&src, pageVars)
+
+	// skip the template if it is a draft, or is not published yet or has expired
+	now := time.Now()
+	visible, next := comp.pageVisible(pageVars, now)
+	if !visible {
+		comp.schedulePage(strings.Join(append(uriPath[:len(uriPath):len(uriPath)], "*"), "/"), next)
+		return
+	}
+
+	// translations use the records of the page without its locale prefix
+	_, rest := comp.pageLocale(uriPath)
+
+	var records []Data
+	if terms, ok := comp.taxonomyTermList(uriPath); ok && param == "term" {
+		records = make([]Data, len(terms))
+		for i, term := range terms {
+			records[i] = Data{"term": term}
+		}
+		param = "term.slug"
+	} else {
+		source := varString(pageVars["source"])
+		if source == "" {
+			source = strings.Join(rest, ".")
+		}
+		records = comp.sourceRecords(source, strings.Join(rest, "/"))
+	}
+
+	if strings.HasSuffix(path, ".md") {
+		comp.compileMD(&src, path)
+	}
+
+	rel, err := filepath.Rel(comp.config.Root, path)
+	if err != nil {
+		rel = path
+	}
+
+	buf := comp.loadLayout(varString(pageVars["layout"]))
+	buf = regex.Comp(`\{@body\}`+regLayoutMark+`?`).RepLit(buf, src)
+
+	configVars := comp.compPage(&buf, uriPath, includeFile{path: rel, src: src})
+	for key, val := range pageVars {
+		configVars[key] = val
+	}
+
+	pages := []string{}
+	for _, record := range records {
+		// skip drafts, and records that are not published yet or have expired
+		visible, at := comp.pageVisible(record, now)
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+		if !visible {
+			continue
+		}
+
+		// the url of the page is the value of the param, so it matches the keys of the data source
+		val, _ := varPath(record, param)
+		name := url.PathEscape(strings.TrimSpace(varString(val)))
+		if name == "" || name == "." || name == ".." {
+			PrintMsg("error", "Page Param Missing: "+rel+": {"+param+"}", 50, true)
+			continue
+		}
+
+		out, err := goutil.JoinPath(dist, name)
+		if err != nil || goutil.Contains(pages, out) {
+			continue
+		}
+
+		vars := Data{}
+		for key, val := range configVars {
+			vars[key] = val
+		}
+		for key, val := range record {
+			vars[key] = val
+		}
+
+		b := goutil.CloneBytes(buf)
+		comp.compVars(&b, append(uriPath[:len(uriPath):len(uriPath)], name), false, vars)
+		comp.writePage(out, b, vars)
+		comp.addSitemapPage("/"+strings.Join(append(uriPath[:len(uriPath):len(uriPath)], name), "/"), out, vars, path)
+
+		pages = append(pages, out)
+	}
+
+	comp.schedulePage(strings.Join(append(uriPath[:len(uriPath):len(uriPath)], "*"), "/"), next)
+
+	comp.srcMU.Lock()
+	if comp.genPages == nil {
+		comp.genPages = map[string][]string{}
+	}
+	comp.genPages[dist] = pages
+	comp.srcMU.Unlock()
+}
+
+// sourceRecords returns the records of a data source
+//
+// sources registered with app.DataSource are used before data files.
+// the page is rebuilt when the source changes
+func (comp *compiler) sourceRecords(source string, page string) []Data {
+	// record the data dependency, even if the source is a Go function
+	val, _ := dataVars{comp: comp, page: page}.get("data." + source)
+
+	comp.srcMU.RLock()
+	cb, ok := comp.sources[source]
+	comp.srcMU.RUnlock()
+
+	if ok {
+		list, err := cb()
+		if err != nil {
+			PrintMsg("error", "Data Source Error: "+source+": "+err.Error(), 50, true)
+			return []Data{}
+		}
+		return list
+	}
+
+	list, _ := varList(val)
+
+	records := []Data{}
+	for _, item := range list {
+		switch item := item.(type) {
+		case Data:
+			records = append(records, item)
+		case map[string]any:
+			records = append(records, Data(item))
+		}
+	}
+	return records
+}
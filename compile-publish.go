@@ -0,0 +1,87 @@
+package webx
+
+import (
+	"strings"
+	"time"
+)
+
+// pageVisible returns false if a page is a draft, is scheduled to publish later, or has expired
+//
+// drafts (`draft: yes`) are only compiled in debug mode.
+// also returns the next time the page will be published or expire (zero if never)
+func (comp *compiler) pageVisible(vars Data, now time.Time) (bool, time.Time) {
+	if isTruthy(vars["draft"]) && !comp.config.DebugMode {
+		return false, time.Time{}
+	}
+
+	if publish, ok := varTime(vars["publish"]); ok && now.Before(publish) {
+		return false, publish
+	}
+
+	if expires, ok := varTime(vars["expires"]); ok {
+		if !now.Before(expires) {
+			return false, time.Time{}
+		}
+		return true, expires
+	}
+
+	return true, time.Time{}
+}
+
+// schedulePage sets the next time a page needs to be compiled again
+//
+// @path: the path of the page (or `path/*` for the generated pages in a directory)
+// @at: the time to compile the page (zero to remove it from the schedule)
+func (comp *compiler) schedulePage(path string, at time.Time) {
+	comp.schMU.Lock()
+	defer comp.schMU.Unlock()
+
+	if comp.schedule == nil {
+		comp.schedule = map[string]time.Time{}
+	}
+
+	if at.IsZero() {
+		delete(comp.schedule, path)
+	} else {
+		comp.schedule[path] = at
+	}
+}
+
+// compScheduledPages compiles the pages that have been published or expired since the last check
+//
+// this runs every minute, and also updates the collections and taxonomies that list the pages
+func (comp *compiler) compScheduledPages() {
+	now := time.Now()
+
+	comp.schMU.Lock()
+	paths := []string{}
+	for path, at := range comp.schedule {
+		if !now.Before(at) {
+			paths = append(paths, strings.TrimSuffix(strings.TrimSuffix(path, "*"), "/"))
+			delete(comp.schedule, path)
+		}
+	}
+	comp.schMU.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+
+	comp.loadCollections()
+	full := comp.loadTaxonomies()
+
+	for _, path := range paths {
+		if path == "" || comp.inCollection(path) {
+			full = true
+		}
+	}
+
+	if full {
+		comp.compPages()
+		return
+	}
+
+	for _, path := range paths {
+		comp.compPages(strings.Split(path, "/")...)
+	}
+}
@@ -0,0 +1,190 @@
+package webx
+
+import (
+	"html"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	mdlex "github.com/tdewolff/parse/v2/html"
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// sanitizeTags are the html tags allowed in untrusted content, with their allowed attributes
+var sanitizeTags = map[string][]string{
+	"a": {"href", "title", "rel", "target"}, "abbr": {"title"}, "b": {}, "blockquote": {"cite"}, "br": {},
+	"button": {"type", "aria-label"}, "caption": {}, "cite": {}, "code": {}, "dd": {}, "del": {"cite"},
+	"details": {"open"}, "dfn": {}, "div": {}, "dl": {}, "dt": {}, "em": {}, "figcaption": {}, "figure": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {}, "hr": {}, "i": {},
+	"img": {"src", "alt", "title", "width", "height"}, "ins": {"cite"}, "kbd": {}, "li": {"value"}, "mark": {},
+	"ol": {"start", "reversed"}, "p": {}, "pre": {"data-lang"}, "q": {"cite"}, "s": {}, "samp": {}, "small": {},
+	"span": {"data-line"}, "strike": {}, "strong": {}, "sub": {}, "summary": {}, "sup": {}, "table": {},
+	"tbody": {}, "td": {"align", "colspan", "rowspan"}, "tfoot": {}, "th": {"align", "colspan", "rowspan", "scope"},
+	"thead": {}, "tr": {}, "u": {}, "ul": {}, "var": {},
+}
+
+// sanitizeGlobalAttrs are the attributes allowed on every tag
+var sanitizeGlobalAttrs = []string{"class", "id", "lang", "dir"}
+
+// sanitizeDropTags are removed with their content, rather than just the tag
+var sanitizeDropTags = []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea", "select", "title", "xmp", "plaintext", "head", "frameset", "noembed", "noframes"}
+
+// sanitizeVoidTags have no end tag
+var sanitizeVoidTags = []string{"br", "hr", "img"}
+
+// regSanitizeClass matches the classes that the markdown compiler adds (code highlighting, callouts, and footnotes)
+var regSanitizeClass = `^(?:hl-[a-z]+|line|code|linenos|code-copy|language-[\w+#\-]+|callout|callout-[a-z]+|footnotes|footnote-ref|footnote-return)$`
+
+// regSanitizeID matches the ids of untrusted content, which need a prefix so they can not replace the ids of the page
+var regSanitizeID = `^(?:fn:|fnref:)?user-content-[\w\-:\.]*$`
+
+// sanitizeHTML removes everything from untrusted html that is not in an allowlist
+//
+// scripts, styles, event handlers, and unsafe urls (like `javascript:`) are removed.
+// unknown tags are removed, but keep their text
+//
+// @textHook: optional, changes the escaped text between tags (i.e. to add back trusted html from placeholders)
+func sanitizeHTML(buf []byte, textHook func(text []byte) []byte) []byte {
+	res := []byte{}
+	open := []string{}
+	skip := ""
+	tag := ""
+	attrs := []byte{}
+
+	lexer := mdlex.NewLexer(parse.NewInputBytes(buf))
+	for {
+		tt, data := lexer.Next()
+
+		if skip != "" {
+			if tt == mdlex.ErrorToken {
+				break
+			} else if tt == mdlex.EndTagToken && strings.ToLower(string(lexer.Text())) == skip {
+				skip = ""
+			}
+			continue
+		}
+
+		switch tt {
+		case mdlex.ErrorToken:
+			// close the tags that are still open
+			for i := len(open) - 1; i >= 0; i-- {
+				res = regex.JoinBytes(res, "</", open[i], '>')
+			}
+			return res
+		case mdlex.TextToken:
+			text := []byte(html.EscapeString(html.UnescapeString(string(data))))
+			if textHook != nil {
+				text = textHook(text)
+			}
+			res = append(res, text...)
+		case mdlex.StartTagToken:
+			tag = string(lexer.Text())
+			attrs = []byte{}
+
+			if goutil.Contains(sanitizeDropTags, tag) {
+				skip = tag
+				tag = ""
+			} else if _, ok := sanitizeTags[tag]; !ok {
+				tag = ""
+			}
+		case mdlex.AttributeToken:
+			if tag == "" {
+				break
+			}
+
+			name := string(lexer.AttrKey())
+			if !goutil.Contains(sanitizeTags[tag], name) && !goutil.Contains(sanitizeGlobalAttrs, name) {
+				break
+			}
+
+			val := lexer.AttrVal()
+			if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+				val = val[1 : len(val)-1]
+			}
+
+			if val, ok := sanitizeAttr(tag, name, html.UnescapeString(string(val))); ok {
+				attrs = regex.JoinBytes(attrs, ' ', name, `="`, html.EscapeString(val), '"')
+			}
+		case mdlex.StartTagCloseToken, mdlex.StartTagVoidToken:
+			if tag == "" {
+				break
+			}
+
+			// links that open in a new tab can not access the page
+			if tag == "a" && regex.Comp(`\starget="_blank"`).Match(attrs) {
+				attrs = regex.Comp(`\srel="[^"]*"`).RepLit(attrs, []byte{})
+				attrs = append(attrs, ` rel="nofollow noopener noreferrer"`...)
+			}
+
+			res = regex.JoinBytes(res, '<', tag, attrs, '>')
+			if !goutil.Contains(sanitizeVoidTags, tag) {
+				open = append(open, tag)
+			}
+			tag = ""
+		case mdlex.EndTagToken:
+			name := strings.ToLower(string(lexer.Text()))
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					for j := len(open) - 1; j >= i; j-- {
+						res = regex.JoinBytes(res, "</", open[j], '>')
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+
+		// comments, doctypes, svg, and math are removed
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		res = regex.JoinBytes(res, "</", open[i], '>')
+	}
+	return res
+}
+
+// sanitizeAttr checks the value of an allowed attribute
+func sanitizeAttr(tag string, name string, val string) (string, bool) {
+	switch name {
+	case "href", "src", "cite":
+		return val, safeURL(val)
+	case "class":
+		list := []string{}
+		for _, class := range strings.Fields(val) {
+			if regex.Comp(regSanitizeClass).RE.MatchString(class) {
+				list = append(list, class)
+			}
+		}
+		return strings.Join(list, " "), len(list) != 0
+	case "id":
+		return val, regex.Comp(regSanitizeID).RE.MatchString(val)
+	case "target":
+		return val, val == "_blank"
+	case "rel":
+		return "nofollow", true
+	case "type":
+		return val, tag == "button" && val == "button"
+	case "width", "height", "colspan", "rowspan", "start", "value":
+		return val, regex.Comp(`^[0-9]{1,5}$`).RE.MatchString(val)
+	case "align":
+		return val, goutil.Contains([]string{"left", "right", "center"}, val)
+	}
+	return val, true
+}
+
+// safeURL returns false for urls with a scheme other than http, https, or mailto
+func safeURL(url string) bool {
+	// browsers ignore whitespace and control characters in the scheme
+	url = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url))
+
+	scheme := regex.Comp(`^([a-z][a-z0-9+\.\-]*):`).RE.FindStringSubmatch(url)
+	if scheme == nil {
+		return true
+	}
+	return goutil.Contains([]string{"http", "https", "mailto"}, scheme[1])
+}
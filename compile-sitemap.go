@@ -0,0 +1,204 @@
+package webx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkdeng/regex"
+)
+
+// sitemapLimit is the max number of urls in a sitemap, before it is split into a sitemap index
+var sitemapLimit = 50000
+
+type sitemapPage struct {
+	url        string
+	dist       string
+	lastmod    time.Time
+	priority   string
+	changefreq string
+}
+
+// addSitemapPage adds a compiled page to the sitemap
+//
+// pages with `sitemap: no` or `noindex: yes` in their front matter are left out,
+// and `lastmod` defaults to the last modified time of the source files
+//
+// @dist: the path of the page in the dist directory, without the .html extension
+func (comp *compiler) addSitemapPage(url string, dist string, configVars Data, src ...string) {
+	if (configVars["sitemap"] != nil && !isTruthy(configVars["sitemap"])) || isTruthy(configVars["noindex"]) {
+		comp.removeSitemapPage(url)
+		return
+	}
+
+	page := sitemapPage{url: url, dist: dist}
+
+	if t, ok := varTime(configVars["lastmod"]); ok {
+		page.lastmod = t
+	} else {
+		for _, path := range src {
+			if stat, err := os.Stat(path); err == nil && stat.ModTime().After(page.lastmod) {
+				page.lastmod = stat.ModTime()
+			}
+		}
+	}
+
+	if n, err := strconv.ParseFloat(varString(configVars["priority"]), 64); err == nil && n >= 0 && n <= 1 {
+		page.priority = strconv.FormatFloat(n, 'f', 1, 64)
+	}
+
+	switch freq := strings.ToLower(varString(configVars["changefreq"])); freq {
+	case "always", "hourly", "daily", "weekly", "monthly", "yearly", "never":
+		page.changefreq = freq
+	}
+
+	comp.mapMU.Lock()
+	if comp.sitemap == nil {
+		comp.sitemap = map[string]sitemapPage{}
+	}
+	comp.sitemap[url] = page
+	comp.mapMU.Unlock()
+
+	comp.updateSitemap()
+}
+
+// removeSitemapPage removes a page from the sitemap
+func (comp *compiler) removeSitemapPage(url string) {
+	comp.mapMU.Lock()
+	_, ok := comp.sitemap[url]
+	delete(comp.sitemap, url)
+	comp.mapMU.Unlock()
+
+	if ok {
+		comp.updateSitemap()
+	}
+}
+
+// updateSitemap writes the sitemap after live changes stop for a moment
+func (comp *compiler) updateSitemap() {
+	comp.mapMU.Lock()
+	defer comp.mapMU.Unlock()
+
+	if !comp.live {
+		return
+	}
+
+	if comp.mapTimer != nil {
+		comp.mapTimer.Stop()
+	}
+	comp.mapTimer = time.AfterFunc(500*time.Millisecond, comp.writeSitemap)
+}
+
+// writeSitemap writes the `sitemap.xml` and `robots.txt` files to the dist directory
+//
+// the sitemap needs the `base_url` of the site in the app config.
+// sites with more than 50000 pages get a sitemap index, with the pages split into `sitemap-1.xml`, `sitemap-2.xml`, ...
+//
+// a `robots.txt` file in the pages directory will be used instead of the default
+func (comp *compiler) writeSitemap() {
+	dist := comp.config.Root + "/dist"
+	baseURL := strings.TrimRight(comp.config.BaseURL, "/")
+
+	os.Remove(dist + "/sitemap.xml")
+	if files, err := filepath.Glob(dist + "/sitemap-*.xml"); err == nil {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+
+	// robots.txt
+	robots, err := os.ReadFile(comp.config.Root + "/pages/robots.txt")
+	if err != nil {
+		robots = []byte("User-agent: *\nDisallow:\n")
+	}
+	if baseURL != "" && !regex.Comp(`(?im)^\s*sitemap\s*:`).Match(robots) {
+		robots = regex.JoinBytes(bytes.TrimRight(robots, "\r\n"), "\n\nSitemap: ", baseURL, "/sitemap.xml\n")
+	}
+	os.MkdirAll(dist, 0755)
+	os.WriteFile(dist+"/robots.txt", robots, 0755)
+
+	if baseURL == "" {
+		return
+	}
+
+	comp.mapMU.Lock()
+	list := []sitemapPage{}
+	for _, page := range comp.sitemap {
+		list = append(list, page)
+	}
+	comp.mapMU.Unlock()
+
+	// skip pages that have been removed
+	pages := []sitemapPage{}
+	for _, page := range list {
+		for _, path := range []string{page.dist + ".html", page.dist + ".html.gz", string(regex.Comp(`\/([^\/]+)$`).Rep([]byte(page.dist), []byte("/#$1"))) + ".html"} {
+			if _, err := os.Stat(path); err == nil {
+				pages = append(pages, page)
+				break
+			}
+		}
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].url < pages[j].url
+	})
+
+	urlset := func(pages []sitemapPage) []byte {
+		buf := []byte(xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+		for _, page := range pages {
+			buf = regex.JoinBytes(buf, `<url><loc>`, xmlEscape(baseURL+page.url), `</loc>`)
+			if !page.lastmod.IsZero() {
+				buf = regex.JoinBytes(buf, `<lastmod>`, page.lastmod.Format(time.RFC3339), `</lastmod>`)
+			}
+			if page.changefreq != "" {
+				buf = regex.JoinBytes(buf, `<changefreq>`, page.changefreq, `</changefreq>`)
+			}
+			if page.priority != "" {
+				buf = regex.JoinBytes(buf, `<priority>`, page.priority, `</priority>`)
+			}
+			buf = append(buf, "</url>\n"...)
+		}
+		return append(buf, "</urlset>\n"...)
+	}
+
+	if len(pages) <= sitemapLimit {
+		os.WriteFile(dist+"/sitemap.xml", urlset(pages), 0755)
+		return
+	}
+
+	index := []byte(xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for i := 0; i*sitemapLimit < len(pages); i++ {
+		list := pages[i*sitemapLimit : min((i+1)*sitemapLimit, len(pages))]
+		name := "/sitemap-" + strconv.Itoa(i+1) + ".xml"
+
+		os.WriteFile(dist+name, urlset(list), 0755)
+
+		lastmod := time.Time{}
+		for _, page := range list {
+			if page.lastmod.After(lastmod) {
+				lastmod = page.lastmod
+			}
+		}
+
+		index = regex.JoinBytes(index, `<sitemap><loc>`, xmlEscape(baseURL+name), `</loc>`)
+		if !lastmod.IsZero() {
+			index = regex.JoinBytes(index, `<lastmod>`, lastmod.Format(time.RFC3339), `</lastmod>`)
+		}
+		index = append(index, "</sitemap>\n"...)
+	}
+	index = append(index, "</sitemapindex>\n"...)
+
+	os.WriteFile(dist+"/sitemap.xml", index, 0755)
+}
+
+// xmlEscape escapes text for xml
+func xmlEscape(text string) []byte {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.Bytes()
+}
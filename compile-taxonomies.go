@@ -0,0 +1,197 @@
+package webx
+
+import (
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/tkdeng/regex"
+)
+
+// loadTaxonomies groups the pages by the terms in their front matter lists
+//
+// taxonomies are listed in the app `config.yml`:
+//
+//	taxonomies: [tags, categories]
+//
+// each locale has its own terms, with the translations of the pages.
+// returns true if the terms (or the pages listed by them) have changed
+func (comp *compiler) loadTaxonomies() bool {
+	taxonomies := map[string]Data{}
+
+	names := []string{}
+	for _, name := range comp.config.Taxonomies {
+		if name = taxonomyName(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	// the url paths of the pages, from the shared and locale trees
+	paths := [][]string{}
+	seen := map[string]bool{}
+	if len(names) != 0 {
+		root := comp.config.Root + "/pages"
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil
+			}
+
+			parts := []string{}
+			if rel != "." {
+				parts = strings.Split(filepath.ToSlash(rel), "/")
+			}
+
+			// pages in a locale tree (i.e. pages/es/about) have the same path as the shared tree
+			if len(parts) != 0 && comp.isLocale(parts[0]) {
+				parts = parts[1:]
+			}
+
+			if !seen[strings.Join(parts, "/")] {
+				seen[strings.Join(parts, "/")] = true
+				paths = append(paths, parts)
+			}
+
+			return nil
+		})
+	}
+
+	for _, locale := range comp.siteLocales() {
+		taxonomies[locale] = Data{}
+		if len(names) == 0 {
+			continue
+		}
+
+		terms := map[string]map[string]Data{}
+		for _, name := range names {
+			terms[name] = map[string]Data{}
+		}
+
+		for _, parts := range paths {
+			url := comp.localeURL(locale, parts)
+			page, ok := comp.loadPageVars(comp.pageDirs(strings.Split(strings.Trim(url, "/"), "/")), locale, url)
+			if !ok {
+				continue
+			}
+
+			for _, name := range names {
+				for _, term := range taxonomyTerms(page[name]) {
+					slug := termSlug(term)
+					if slug == "" {
+						continue
+					}
+
+					if _, ok := terms[name][slug]; !ok {
+						terms[name][slug] = Data{
+							"name":  term,
+							"slug":  slug,
+							"url":   comp.localeURL(locale, []string{name, slug}),
+							"pages": []Data{},
+						}
+					}
+
+					terms[name][slug]["pages"] = append(terms[name][slug]["pages"].([]Data), page)
+				}
+			}
+		}
+
+		for _, name := range names {
+			list := []Data{}
+			for _, term := range terms[name] {
+				sortVars(term["pages"].([]Data), "date", true)
+				term["count"] = len(term["pages"].([]Data))
+				list = append(list, term)
+			}
+			sortVars(list, "name", false)
+
+			taxonomies[locale]["taxonomies."+name] = list
+		}
+	}
+
+	comp.taxMU.Lock()
+	defer comp.taxMU.Unlock()
+
+	changed := !reflect.DeepEqual(comp.taxonomies, taxonomies)
+	comp.taxonomies = taxonomies
+
+	return changed
+}
+
+// taxonomyTerms returns the terms of a front matter value
+//
+// both lists (`tags: [go, web]`) and comma separated text (`tags: go, web`) are accepted
+func taxonomyTerms(val any) []string {
+	terms := []string{}
+
+	if list, ok := varList(val); ok {
+		for _, v := range list {
+			if term := strings.TrimSpace(varString(v)); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		return terms
+	}
+
+	for _, term := range strings.Split(varString(val), ",") {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termSlug converts a term to a url friendly name
+func termSlug(term string) string {
+	return strings.Trim(string(regex.Comp(`[^\p{L}\p{N}_]+`).RepLit([]byte(strings.ToLower(term)), []byte{'-'})), "-_")
+}
+
+// taxonomyName cleans a taxonomy name from the app config
+func taxonomyName(name string) string {
+	name = strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+	if name == "." || strings.ContainsAny(name, "/.") {
+		return ""
+	}
+	return name
+}
+
+// taxonomyVars returns the {taxonomies.name} vars of the locale of a page,
+// and the {taxonomy} var for the overview page of a taxonomy
+func (comp *compiler) taxonomyVars(uriPath []string) Data {
+	locale, rest := comp.pageLocale(uriPath)
+
+	comp.taxMU.RLock()
+	defer comp.taxMU.RUnlock()
+
+	vars := Data{}
+	for key, val := range comp.taxonomies[locale] {
+		vars[key] = val
+	}
+
+	if len(rest) == 1 {
+		if list, ok := comp.taxonomies[locale]["taxonomies."+rest[0]]; ok {
+			vars["taxonomy"] = list
+		}
+	}
+
+	return vars
+}
+
+// taxonomyTermList returns the terms of a taxonomy in the locale of a page,
+// and false if uriPath is not the directory of a taxonomy
+func (comp *compiler) taxonomyTermList(uriPath []string) ([]Data, bool) {
+	locale, rest := comp.pageLocale(uriPath)
+	if len(rest) != 1 {
+		return nil, false
+	}
+
+	comp.taxMU.RLock()
+	defer comp.taxMU.RUnlock()
+
+	terms, ok := comp.taxonomies[locale]["taxonomies."+rest[0]].([]Data)
+	return terms, ok
+}
@@ -0,0 +1,138 @@
+package webx
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/tkdeng/regex"
+)
+
+// tocDepth is the default number of heading levels in a table of contents (h2 to h4)
+var tocDepth = 3
+
+// tocList is the table of contents of a page
+//
+// it can be used as a list in {*toc} loops, and {#toc} embeds it as a nested html list
+type tocList []Data
+
+// String returns the table of contents as a nested html list
+func (toc tocList) String() string {
+	if len(toc) == 0 {
+		return ""
+	}
+
+	buf := []byte("<ul>")
+	for _, item := range toc {
+		buf = regex.JoinBytes(buf, `<li><a href="`, html.EscapeString(varString(item["url"])), `">`, html.EscapeString(varString(item["title"])), `</a>`)
+		if children, ok := item["children"].(tocList); ok {
+			buf = append(buf, children.String()...)
+		}
+		buf = append(buf, "</li>"...)
+	}
+	return string(append(buf, "</ul>"...))
+}
+
+// compTOC returns the table of contents of a page, from its h2 to h4 headings
+//
+// headings without an id get one (i.e. `h-getting-started`, with the markdown `heading-prefix`), so they can be linked to.
+// headings inside <nav> and <aside> elements are left out.
+//
+// the front matter of a page can set the number of heading levels with `tocdepth: 2` (h2 to h3),
+// or turn it off with `toc: no`
+func (comp *compiler) compTOC(buf *[]byte, configVars Data, lookup func(name string) (any, bool)) tocList {
+	if val, ok := configVars["toc"]; ok && !isTruthy(val) {
+		return tocList{}
+	}
+
+	depth := tocDepth
+	if n, err := strconv.Atoi(varString(configVars["tocdepth"])); err == nil && n > 0 {
+		depth = min(n, 5)
+	}
+
+	skip := regex.Comp(`(?si)<(?:nav|aside)\b.*?</(?:nav|aside)\s*>`).RE.FindAllIndex(*buf, -1)
+
+	ids := map[string]bool{}
+	for _, m := range regex.Comp(`\sid="([^"]*)"`).RE.FindAllSubmatch(*buf, -1) {
+		ids[string(m[1])] = true
+	}
+
+	items := []Data{}
+	res := []byte{}
+	last := 0
+
+	for _, m := range regex.Comp(`(?si)<h([2-6])(\s[^>]*|)>(.*?)</h[2-6]\s*>`).RE.FindAllSubmatchIndex(*buf, -1) {
+		level := int((*buf)[m[2]] - '0')
+		if level > depth+1 {
+			continue
+		}
+
+		inNav := false
+		for _, s := range skip {
+			if m[0] >= s[0] && m[0] < s[1] {
+				inNav = true
+				break
+			}
+		}
+		if inNav {
+			continue
+		}
+
+		// render vars in the heading text, and remove html tags
+		title := comp.renderTemp(parseTemp((*buf)[m[6]:m[7]]), lookup, false)
+		title = regex.Comp(`<[^>]*>`).RepLit(title, []byte{})
+		text := strings.Join(strings.Fields(html.UnescapeString(string(title))), " ")
+		if text == "" {
+			continue
+		}
+
+		var id string
+		if attr := regex.Comp(`\sid="([^"]*)"`).RE.FindSubmatch((*buf)[m[4]:m[5]]); attr != nil {
+			id = html.UnescapeString(string(comp.renderTemp(parseTemp(attr[1]), lookup, false)))
+		} else {
+			slug := termSlug(text)
+			if slug == "" {
+				slug = "section"
+			}
+
+			prefix := comp.mdConfig().headingPrefix()
+			id = prefix + slug
+			for i := 1; ids[id]; i++ {
+				id = prefix + slug + "-" + strconv.Itoa(i)
+			}
+			ids[id] = true
+
+			res = regex.JoinBytes(res, (*buf)[last:m[3]], ` id="`, html.EscapeString(id), '"')
+			last = m[3]
+		}
+
+		items = append(items, Data{
+			"title": text,
+			"id":    id,
+			"url":   "#" + id,
+			"level": level,
+		})
+	}
+
+	if last != 0 {
+		*buf = append(res, (*buf)[last:]...)
+	}
+
+	return tocTree(items)
+}
+
+// tocTree nests headings under the previous heading with a lower level
+func tocTree(items []Data) tocList {
+	list := tocList{}
+	for i := 0; i < len(items); {
+		j := i + 1
+		for j < len(items) && items[j]["level"].(int) > items[i]["level"].(int) {
+			j++
+		}
+
+		items[i]["children"] = tocTree(items[i+1 : j])
+		list = append(list, items[i])
+		i = j
+	}
+	return list
+}
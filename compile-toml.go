@@ -0,0 +1,58 @@
+package webx
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses a toml data file
+//
+// dates and times are kept as text, in the same format as the file
+// (offset date-times as RFC 3339, and local date-times, dates, and times without a zone)
+func parseTOML(buf []byte) (map[string]any, error) {
+	res := map[string]any{}
+	if err := toml.Unmarshal(buf, &res); err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, errors.New("line " + strconv.Itoa(pe.Position.Line) + ": " + pe.Message)
+		}
+		return nil, err
+	}
+
+	return tomlValue(res).(map[string]any), nil
+}
+
+// tomlValue converts the dates and times in a toml value to text
+func tomlValue(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = tomlValue(item)
+		}
+	case []map[string]any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = tomlValue(item)
+		}
+		return list
+	case []any:
+		for i, item := range v {
+			v[i] = tomlValue(item)
+		}
+	case time.Time:
+		// local dates and times have a zone named by their type
+		switch v.Location().String() {
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	return val
+}
@@ -0,0 +1,482 @@
+package webx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkdeng/goutil"
+	"github.com/tkdeng/regex"
+)
+
+// tempNode is a parsed piece of a page template
+//
+// tag types:
+//   - 0: plain text
+//   - '$': {var} (escaped)
+//   - '#': {#var} (raw html)
+//   - '?': {?var}...{:else}...{/var}
+//   - '!': {!var}...{:else}...{/var}
+//   - '*': {*list}...{/list}
+//   - 'r': {rand}, {urand}, {randint}, and {lorem} (rendered on every request)
+type tempNode struct {
+	tag     byte
+	name    string
+	filters []tempFilter
+
+	// src holds the raw text, or the original tag for unresolved vars and blocks
+	src []byte
+
+	body   []*tempNode
+	alt    []*tempNode
+	hasAlt bool
+	end    []byte
+
+	// ctx is the html context a var is embedded in, used for auto escaping
+	// (for blocks, the context at the opening tag, which {:else} starts from)
+	ctx tempCtx
+
+	rand randTag
+}
+
+var regTempTag = regRandTag + `|\{(#|\?|!|\*|/|)([\w_\-\.]+)((?:\s*\|\s*[\w_\-]+(?:\s+(?:"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|[^\s"'\|\}]+))*)*)\s*\}|\{:else\}`
+
+// parseTemp splits a template into text, vars, and nested blocks
+//
+// unmatched block tags are left as plain text
+func parseTemp(buf []byte) []*tempNode {
+	root := &tempNode{tag: '?'}
+	stack := []*tempNode{root}
+
+	ctx := tempCtx{state: 't'}
+
+	addText := func(b []byte) {
+		if len(b) == 0 {
+			return
+		}
+
+		top := stack[len(stack)-1]
+		list := &top.body
+		if top.hasAlt {
+			list = &top.alt
+		}
+
+		if l := len(*list); l != 0 && (*list)[l-1].tag == 0 {
+			(*list)[l-1].src = append((*list)[l-1].src, b...)
+			return
+		}
+
+		*list = append(*list, &tempNode{src: append([]byte{}, b...)})
+	}
+
+	addNode := func(node *tempNode) {
+		top := stack[len(stack)-1]
+		if top.hasAlt {
+			top.alt = append(top.alt, node)
+		} else {
+			top.body = append(top.body, node)
+		}
+	}
+
+	// vars at the start of a url keep the text after them, so the scheme of the whole url can be checked
+	var schemeVar *tempNode
+
+	feed := func(b []byte) {
+		for len(b) != 0 && schemeVar != nil {
+			if (ctx.state != 'a' && ctx.state != 'u') || ctx.attr != schemeVar.ctx.attr || bytes.ContainsAny(schemeVar.ctx.next, ":/?#") {
+				schemeVar = nil
+				break
+			}
+
+			schemeVar.ctx.next = append(schemeVar.ctx.next, b[0])
+			ctx.feed(b[:1])
+			b = b[1:]
+		}
+		ctx.feed(b)
+	}
+
+	pos := 0
+	for _, m := range regex.Comp(regTempTag).RE.FindAllSubmatchIndex(buf, -1) {
+		addText(buf[pos:m[0]])
+		feed(buf[pos:m[0]])
+		pos = m[1]
+
+		src := buf[m[0]:m[1]]
+
+		// {rand} and {lorem}
+		if m[4] == -1 && !bytes.Equal(src, []byte("{:else}")) {
+			if rt, ok := parseRandTag(src); ok {
+				addNode(&tempNode{tag: 'r', src: src, rand: rt})
+				feed([]byte("x"))
+			} else {
+				addText(src)
+			}
+			continue
+		}
+
+		// {:else}
+		if m[4] == -1 {
+			if top := stack[len(stack)-1]; len(stack) > 1 && !top.hasAlt {
+				top.hasAlt = true
+				ctx = top.ctx.clone()
+			} else {
+				addText(src)
+			}
+			continue
+		}
+
+		tag := byte('$')
+		if m[3] > m[2] {
+			tag = buf[m[2]]
+		}
+		name := string(buf[m[4]:m[5]])
+
+		switch tag {
+		case '$', '#':
+			node := &tempNode{tag: tag, name: name, filters: parseFilters(buf[m[6]:m[7]]), src: src, ctx: ctx.clone()}
+			addNode(node)
+			feed([]byte("x"))
+
+			if tag == '$' && (ctx.state == 'a' || ctx.state == 'u') && goutil.Contains(urlAttrs, ctx.attr) && !bytes.ContainsAny(node.ctx.val, ":/?#") {
+				schemeVar = node
+			}
+		case '?', '!', '*':
+			node := &tempNode{tag: tag, name: name, filters: parseFilters(buf[m[6]:m[7]]), src: src, ctx: ctx.clone()}
+			addNode(node)
+			stack = append(stack, node)
+		case '/':
+			if top := stack[len(stack)-1]; len(stack) > 1 && top.name == name {
+				top.end = src
+				stack = stack[:len(stack)-1]
+			} else {
+				addText(src)
+			}
+		}
+	}
+	addText(buf[pos:])
+	feed(buf[pos:])
+
+	// flatten unclosed blocks back into text
+	for len(stack) > 1 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		parent := stack[len(stack)-1]
+		list := &parent.body
+		if parent.hasAlt {
+			list = &parent.alt
+		}
+		*list = (*list)[:len(*list)-1]
+
+		addText(node.src)
+		for _, n := range node.body {
+			addNode(n)
+		}
+		if node.hasAlt {
+			addText([]byte("{:else}"))
+			for _, n := range node.alt {
+				addNode(n)
+			}
+		}
+	}
+
+	return root.body
+}
+
+// renderTemp renders a parsed template
+//
+// @lookup: returns the value of a variable, and false if it does not exist
+//
+// @dynamic: if true, unknown vars and blocks are kept, so they can be rendered later at request time
+func renderTemp(nodes []*tempNode, lookup func(name string) (any, bool), dynamic bool) []byte {
+	var buf bytes.Buffer
+	writeTemp(&buf, nodes, lookup, dynamic)
+	return buf.Bytes()
+}
+
+// writeTemp renders a parsed template directly to a writer (see renderTemp)
+func writeTemp(w io.Writer, nodes []*tempNode, lookup func(name string) (any, bool), dynamic bool) {
+	tw := tempWriter{w: w, dynamic: dynamic}
+	tw.write(nodes, lookup)
+}
+
+// renderTemp renders a parsed template with the config of the compiler (used by filters like markdown)
+func (comp *compiler) renderTemp(nodes []*tempNode, lookup func(name string) (any, bool), dynamic bool) []byte {
+	var buf bytes.Buffer
+	comp.writeTemp(&buf, nodes, lookup, dynamic)
+	return buf.Bytes()
+}
+
+// writeTemp renders a parsed template directly to a writer, with the config of the compiler
+func (comp *compiler) writeTemp(w io.Writer, nodes []*tempNode, lookup func(name string) (any, bool), dynamic bool) {
+	tw := tempWriter{w: w, dynamic: dynamic, comp: comp}
+	tw.write(nodes, lookup)
+}
+
+type tempWriter struct {
+	w       io.Writer
+	dynamic bool
+
+	// comp is the compiler the template is rendered by (nil outside of an app)
+	comp *compiler
+
+	// request is true when a dynamic page is rendered at request time
+	request bool
+
+	// urand keeps {urand} values unique within a page
+	urand [][]byte
+}
+
+func (tw *tempWriter) write(nodes []*tempNode, lookup func(name string) (any, bool)) {
+	for _, node := range nodes {
+		switch node.tag {
+		case 0:
+			tw.w.Write(node.src)
+		case 'r':
+			if tw.dynamic {
+				tw.w.Write(node.src)
+			} else {
+				tw.w.Write(node.rand.gen(&tw.urand))
+			}
+		case '$', '#':
+			val, ok := lookup(node.name)
+			if !ok && tw.dynamic {
+				tw.w.Write(node.src)
+				break
+			}
+
+			val = applyFilters(tw.comp, tw.request, val, node.filters)
+			if !ok && len(node.filters) == 0 {
+				break
+			}
+
+			if node.tag == '#' {
+				io.WriteString(tw.w, varString(val))
+			} else {
+				tw.w.Write(escapeVar(val, node.ctx))
+			}
+		case '?', '!':
+			val, ok := lookup(node.name)
+			if !ok && tw.dynamic {
+				tw.writeBlock(node, lookup)
+				break
+			}
+
+			val = applyFilters(tw.comp, tw.request, val, node.filters)
+			ok = ok || len(node.filters) != 0
+
+			if (ok && isTruthy(val)) == (node.tag == '?') {
+				tw.write(node.body, lookup)
+			} else {
+				tw.write(node.alt, lookup)
+			}
+		case '*':
+			val, ok := lookup(node.name)
+			if !ok && tw.dynamic {
+				tw.writeBlock(node, lookup)
+				break
+			}
+
+			val = applyFilters(tw.comp, tw.request, val, node.filters)
+
+			list := reflect.ValueOf(val)
+			if !ok || (list.Kind() != reflect.Slice && list.Kind() != reflect.Array) || list.Len() == 0 {
+				tw.write(node.alt, lookup)
+				break
+			}
+
+			size := list.Len()
+			for i := 0; i < size; i++ {
+				item := list.Index(i).Interface()
+
+				tw.write(node.body, func(name string) (any, bool) {
+					if name == "." {
+						return item, true
+					} else if !strings.HasPrefix(name, ".") {
+						return lookup(name)
+					}
+
+					switch name {
+					case ".index":
+						return i, true
+					case ".first":
+						return i == 0, true
+					case ".last":
+						return i == size-1, true
+					}
+
+					return varPath(item, name[1:])
+				})
+			}
+		}
+	}
+}
+
+// writeBlock keeps an unresolved block, and renders its content
+func (tw *tempWriter) writeBlock(node *tempNode, lookup func(name string) (any, bool)) {
+	tw.w.Write(node.src)
+	tw.write(node.body, lookup)
+	if node.hasAlt {
+		io.WriteString(tw.w, "{:else}")
+		tw.write(node.alt, lookup)
+	}
+	tw.w.Write(node.end)
+}
+
+// varPath returns a nested value by its dot path (i.e. "user.profile.name")
+func varPath(val any, path string) (any, bool) {
+	for _, name := range strings.Split(path, ".") {
+		var ok bool
+		if val, ok = varField(val, name); !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+// varField returns a field from a map, struct, or list
+//
+// struct fields can be matched by name (case insensitive) or by their json tag,
+// and map keys must match exactly
+func varField(val any, name string) (any, bool) {
+	ref := reflect.ValueOf(val)
+	for ref.Kind() == reflect.Pointer || ref.Kind() == reflect.Interface {
+		if ref.IsNil() {
+			return nil, false
+		}
+		ref = ref.Elem()
+	}
+
+	switch ref.Kind() {
+	case reflect.Map:
+		if ref.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+
+		field := ref.MapIndex(reflect.ValueOf(name).Convert(ref.Type().Key()))
+		if field.IsValid() {
+			return field.Interface(), true
+		}
+	case reflect.Struct:
+		t := ref.Type()
+
+		if f, ok := t.FieldByName(name); ok && f.IsExported() {
+			return ref.FieldByIndex(f.Index).Interface(), true
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == name || (tag == "" && strings.EqualFold(f.Name, name)) {
+				return ref.Field(i).Interface(), true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < ref.Len() {
+			return ref.Index(i).Interface(), true
+		}
+	}
+
+	return nil, false
+}
+
+// varString converts a variable to a string
+func varString(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case HTML:
+		return string(v)
+	case urlEncoded:
+		return string(v)
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	ref := reflect.ValueOf(val)
+	for ref.Kind() == reflect.Pointer {
+		if ref.IsNil() {
+			return ""
+		}
+		ref = ref.Elem()
+	}
+	return fmt.Sprint(ref.Interface())
+}
+
+// isTruthy returns false for empty values and lists, and for "0", "false", and "no"
+func isTruthy(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "0", "false", "no":
+			return false
+		default:
+			return true
+		}
+	case bool:
+		return v
+	}
+
+	ref := reflect.ValueOf(val)
+	switch ref.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return ref.Len() != 0
+	case reflect.Pointer, reflect.Interface:
+		if ref.IsNil() {
+			return false
+		}
+		return isTruthy(ref.Elem().Interface())
+	}
+	return !ref.IsZero()
+}
+
+// lookupVars returns a lookup function for a list of render vars
+//
+// the first var that has a value will be used,
+// and names can use a dot path for nested values (i.e. "user.profile.name")
+func lookupVars(vars ...Vars) func(name string) (any, bool) {
+	return func(name string) (any, bool) {
+		for _, v := range vars {
+			if v == nil {
+				continue
+			}
+
+			if val, ok := v.get(name); ok {
+				return val, true
+			}
+
+			if key, path, ok := strings.Cut(name, "."); ok && key != "" {
+				if val, ok := v.get(key); ok {
+					if val, ok := varPath(val, path); ok {
+						return val, true
+					}
+				}
+			}
+		}
+		return nil, false
+	}
+}
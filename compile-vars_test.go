@@ -0,0 +1,334 @@
+package webx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tkdeng/regex"
+)
+
+func TestTempBlocks(t *testing.T) {
+	vars := Map{
+		"user":   "admin",
+		"banner": "",
+		"off":    "no",
+	}
+
+	lookup := lookupVars(vars)
+
+	tests := []struct {
+		src     string
+		out     string
+		dynamic bool
+	}{
+		{`{?user}hi {user}{/user}`, `hi admin`, false},
+		{`{?banner}<b>{banner}</b>{:else}none{/banner}`, `none`, false},
+		{`{!off}shown{/off}`, `shown`, false},
+		{`{!user}guest{:else}member{/user}`, `member`, false},
+		{`{?missing}a{:else}b{/missing}`, `b`, false},
+		{`{?user}{?banner}x{:else}y{/banner}{/user}`, `y`, false},
+		{`{?missing}{user}{:else}{other}{/missing}`, `{?missing}admin{:else}{other}{/missing}`, true},
+		{`{?user}open`, `{?user}open`, false},
+		{`close{/user}`, `close{/user}`, false},
+		{`<a>{user}</a>`, `<a>admin</a>`, false},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, test.dynamic)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+}
+
+func TestTempLoops(t *testing.T) {
+	lookup := lookupVars(Data{
+		"items": []Map{
+			{"name": "a"},
+			{"name": "b"},
+			{"name": "c"},
+		},
+		"nav": []any{
+			map[string]any{"name": "Home", "children": []any{"x", "y"}},
+			map[string]any{"name": "About"},
+		},
+		"empty": []Map{},
+		"title": "list",
+	})
+
+	tests := []struct {
+		src     string
+		out     string
+		dynamic bool
+	}{
+		{`{*items}<li>{.name}</li>{/items}`, `<li>a</li><li>b</li><li>c</li>`, false},
+		{`{*items}{.index}{?.first}^{/.first}{!.last},{/.last}{/items}`, `0^,1,2`, false},
+		{`{*items}{title}:{.name} {/items}`, `list:a list:b list:c `, false},
+		{`{*nav}{.name}({*.children}{.}{/.children}){/nav}`, `Home(xy)About()`, false},
+		{`{*empty}x{:else}none{/empty}`, `none`, false},
+		{`{*missing}<b>{.name}</b>{title}{/missing}`, `{*missing}<b>{.name}</b>list{/missing}`, true},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, test.dynamic)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+}
+
+func TestTempData(t *testing.T) {
+	type profile struct {
+		Name  string
+		Email string `json:"mail"`
+	}
+
+	type user struct {
+		ID      int
+		Profile *profile
+		Tags    []string
+		Joined  time.Time
+		Active  bool
+		private string
+	}
+
+	lookup := lookupVars(Map{"site": "webx"}, Data{
+		"user": user{
+			ID:      7,
+			Profile: &profile{Name: "Ann", Email: "ann@example.com"},
+			Tags:    []string{"go", "web"},
+			Joined:  time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+			Active:  true,
+			private: "secret",
+		},
+		"price":  9.5,
+		"nested": map[string]any{"a": map[string]any{"b": "deep"}},
+		"none":   (*profile)(nil),
+	})
+
+	tests := []struct {
+		src string
+		out string
+	}{
+		{`{user.profile.name} <{user.profile.mail}>`, `Ann <ann@example.com>`},
+		{`{user.id} {price} {site}`, `7 9.5 webx`},
+		{`{user.tags.1} {user.joined}`, `web 2025-01-02T03:04:05Z`},
+		{`{nested.a.b}`, `deep`},
+		{`[{user.private}]`, `[]`},
+		{`{?user.active}on{/user.active}{?none}x{:else}nil{/none}`, `onnil`},
+		{`{*user.tags}{.}{!.last},{/.last}{/user.tags}`, `go,web`},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, false)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+}
+
+func TestTempEscape(t *testing.T) {
+	lookup := lookupVars(Data{
+		"text":     `<b>"hi" & 'bye'</b>`,
+		"js":       `javascript:alert(1)`,
+		"url":      `https://example.com/a b`,
+		"path":     `a b/c`,
+		"query":    `x&y=z`,
+		"name":     `"quoted" </script>`,
+		"num":      5,
+		"css":      `red;background:url(x)`,
+		"attr":     `a b`,
+		"site":     ``,
+		"fallback": `javascript:alert(document.domain)`,
+		"scheme":   `javascript`,
+		"slug":     `about`,
+		"code":     `1;alert(document.domain)`,
+	})
+
+	tests := []struct {
+		src string
+		out string
+	}{
+		{`<p>{text}</p>`, `<p>&lt;b&gt;&#34;hi&#34; &amp; &#39;bye&#39;&lt;/b&gt;</p>`},
+		{`<div title="{text}">`, `<div title="&lt;b&gt;&#34;hi&#34; &amp; &#39;bye&#39;&lt;/b&gt;">`},
+		{`<div class={attr}>`, `<div class=a&#x20;b>`},
+		{`<a href="{js}">`, `<a href="#blocked">`},
+		{`<a href="{url}">`, `<a href="https://example.com/a b">`},
+		{`<a href="/user/{path}?q={query}">`, `<a href="/user/a%20b%2Fc?q=x%26y%3Dz">`},
+		{`<script>let a = "{name}"; let b = {name}; let c = {num};</script>`, `<script>let a = "\u0022quoted\u0022 \u003c\u002fscript\u003e"; let b = "\"quoted\" \u003c/script\u003e"; let c = 5;</script>`},
+		{`<button onclick="go('{name}')">`, `<button onclick="go('\u0022quoted\u0022 \u003c\u002fscript\u003e')">`},
+		{`<style>p { color: {css}; }</style>`, `<style>p { color: red\3b background\3a url\28 x\29 ; }</style>`},
+		{`<p style="color: {css}">{#text}</p>`, `<p style="color: red\3b background\3a url\28 x\29 ">` + "<b>\"hi\" & 'bye'</b></p>"},
+		{`<a href="{?site}{site}{:else}{fallback}{/site}">`, `<a href="#blocked">`},
+		{`<a href="{?path}/{path}{:else}{fallback}{/path}">`, `<a href="/a%20b%2Fc">`},
+		{`<a href="{?site}x{:else}{fallback}{/site}">`, `<a href="#blocked">`},
+		{`<a href="{scheme}:alert(1)">`, `<a href="#blocked:alert(1)">`},
+		{`<a href="java{slug}script:{slug}">`, `<a href="java#blockedscript:about">`},
+		{`<a href="{slug}">{slug}:</a>`, `<a href="about">about:</a>`},
+		{`<img alt="a:b" src={js}>`, `<img alt="a:b" src=&#x23;blocked>`},
+		{`<img alt="a:b" src="{js}">`, `<img alt="a:b" src="#blocked">`},
+		{`<a title="Home" href={url}>`, `<a title="Home" href=https&#x3a;&#x2f;&#x2f;example.com&#x2f;a&#x20;b>`},
+		{`<a title="Home" href="{url}">`, `<a title="Home" href="https://example.com/a b">`},
+		{`<a title=Home href="{url}">`, `<a title=Home href="https://example.com/a b">`},
+		{`<iframe srcdoc="{text}">`, `<iframe srcdoc="&amp;lt;b&amp;gt;&amp;#34;hi&amp;#34; &amp;amp; &amp;#39;bye&amp;#39;&amp;lt;/b&amp;gt;">`},
+		{"<script>// don't touch\nvar count = {code};</script>", "<script>// don't touch\nvar count = \"1;alert(document.domain)\";</script>"},
+		{`<script>/* it's */ var a = /'/.test(x) ? {code} : 1 / 2;</script>`, `<script>/* it's */ var a = /'/.test(x) ? "1;alert(document.domain)" : 1 / 2;</script>`},
+		{`<script>var a = 1 / 2, b = '{code}', c = /[/']/g; return /'/, {code};</script>`, `<script>var a = 1 / 2, b = '1;alert(document.domain)', c = /[/']/g; return /'/, "1;alert(document.domain)";</script>`},
+		{"<script>var a = `x ${ {code} } '` + {code};</script>", "<script>var a = `x ${ \"1;alert(document.domain)\" } '` + \"1;alert(document.domain)\";</script>"},
+		{`<button onclick="/'/.test(x) && go({code})">`, `<button onclick="/'/.test(x) && go(&#34;1;alert(document.domain)&#34;)">`},
+		{`<!-- {text} --><i>{num}</i>`, `<!-- &lt;b&gt;&#34;hi&#34; &amp; &#39;bye&#39;&lt;/b&gt; --><i>5</i>`},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, false)); out != test.out {
+			t.Errorf("%s:\n expected %s\n      got %s", test.src, test.out, out)
+		}
+	}
+}
+
+func TestTempFilters(t *testing.T) {
+	NewFilter("shout", func(val any, args ...string) any {
+		return varString(val) + "!"
+	})
+
+	lookup := lookupVars(Data{
+		"title": "hello world",
+		"body":  "a long sentence of words",
+		"price": 1234567.891,
+		"date":  "2025-03-04",
+		"slug":  "a b&c",
+		"md":    "**bold** <i>x</i>",
+		"tags":  []string{"a", "b"},
+		"posts": []Data{
+			{"title": "b", "date": "2025-01-02", "tags": []any{"go"}},
+			{"title": "a", "date": "2025-03-04", "draft": "yes"},
+			{"title": "C", "date": "2024-12-01", "tags": []any{"web", "go"}},
+		},
+	})
+
+	tests := []struct {
+		src     string
+		out     string
+		dynamic bool
+	}{
+		{`{title | upper}`, `HELLO WORLD`, false},
+		{`{title | capitalize | shout}`, `Hello World!`, false},
+		{`{body | truncate 6}`, `a long…`, false},
+		{`{body | truncate 6 "..."}`, `a long...`, false},
+		{`{price | number 2} {price | number}`, `1,234,567.89 1,234,568`, false},
+		{`{date | date "Jan 2, 2006"} {date|date '2006/01/02'}`, `Mar 4, 2025 2025/03/04`, false},
+		{`<a href="/s?q={slug | urlencode}">`, `<a href="/s?q=a+b%26c">`, false},
+		{`<div>{md | markdown}</div>`, "<div><p><strong>bold</strong> <i>x</i></p>\n</div>", false},
+		{`{missing | default "none"}`, `none`, false},
+		{`{missing | default "none"}`, `{missing | default "none"}`, true},
+		{`{tags | join "/"} {tags | length}`, `a/b 2`, false},
+		{`{?tags | length}yes{/tags}`, `yes`, false},
+		{`{title | unknown}`, `hello world`, false},
+		{`{*posts | sort "date"}{.title}{/posts}`, `Cba`, false},
+		{`{*posts | sort "title" "desc"}{.title}{/posts}`, `Cba`, false},
+		{`{*posts | sort "title" | reverse | limit 2}{.title}{/posts}`, `Cb`, false},
+		{`{*posts | limit 5 1}{.title}{/posts}`, `aC`, false},
+		{`{*posts | where "tags" "go"}{.title}{/posts} {*posts | where "draft"}{.title}{/posts}`, `bC a`, false},
+		{`{*tags | sort | reverse}{.}{/tags}`, `ba`, false},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, test.dynamic)); out != test.out {
+			t.Errorf("%s:\n expected %q\n      got %q", test.src, test.out, out)
+		}
+	}
+}
+
+func TestTempRand(t *testing.T) {
+	nodes := parseTemp([]byte(`<p id="{rand 8}">{randint 1}|{lorem w 2}|{random}</p>`))
+
+	if out := string(renderTemp(nodes, lookupVars(), true)); out != `<p id="{rand 8}">{randint 1}|{lorem w 2}|{random}</p>` {
+		t.Errorf("expected rand vars to be kept, got %q", out)
+	}
+
+	out := string(renderTemp(nodes, lookupVars(), false))
+	if !regex.Comp(`^<p id="[^"]{8}">0\|\w+\|</p>$`).Match([]byte(out)) {
+		t.Errorf("expected rand vars to be generated, got %q", out)
+	}
+
+	if out2 := string(renderTemp(nodes, lookupVars(), false)); out2 == out {
+		t.Errorf("expected rand vars to change on every render, got %q", out2)
+	}
+}
+
+// benchDynamicPage writes an @page with a number of repeated sections to a temp file
+func benchDynamicPage(b *testing.B, sections int) (string, Data) {
+	page := []byte(`<!DOCTYPE html><html><head><title>{title}</title></head><body>`)
+	for i := 0; i < sections; i++ {
+		page = append(page, `
+<section id="s-{rand 8}">
+  <h2>{heading | upper}</h2>
+  {?user}<p>Welcome back, {user.name}</p>{:else}<a href="/login?next={next | urlencode}">Login</a>{/user}
+  <ul>{*links}<li><a href="{.href}">{.name}</a>{!.last}, {/.last}</li>{/links}</ul>
+  <p>{lorem s 8}</p>
+</section>`...)
+	}
+	page = append(page, `</body></html>`...)
+
+	path := b.TempDir() + "/@page.html"
+	if err := os.WriteFile(path, page, 0755); err != nil {
+		b.Fatal(err)
+	}
+
+	return path, Data{
+		"heading": "section title",
+		"user":    map[string]any{"name": "Ann"},
+		"next":    "/a b",
+		"links": []Map{
+			{"name": "Home", "href": "/"},
+			{"name": "About", "href": "/about"},
+			{"name": "Blog", "href": "/blog"},
+		},
+	}
+}
+
+func BenchmarkDynamicPage(b *testing.B) {
+	for _, sections := range []int{1, 100, 1000} {
+		path, vars := benchDynamicPage(b, sections)
+		comp := &compiler{config: &Config{Title: "Web Server"}, dynPages: map[string][]*tempNode{}}
+
+		// read and compile the page on every request
+		b.Run(fmt.Sprintf("uncached/%d", sections), func(b *testing.B) {
+			for b.Loop() {
+				buf, err := os.ReadFile(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				lookup := lookupVars(vars, comp.titleVars("", lookupVars(vars)))
+				comp.compRandVars(&buf)
+				io.Discard.Write(renderTemp(parseTemp(buf), lookup, false))
+			}
+		})
+
+		// render the cached template directly to the response
+		b.Run(fmt.Sprintf("cached/%d", sections), func(b *testing.B) {
+			for b.Loop() {
+				nodes, err := comp.dynamicPage(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				comp.renderDynamicPage(io.Discard, nodes, vars)
+			}
+		})
+
+		b.Run(fmt.Sprintf("cached-parallel/%d", sections), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					nodes, err := comp.dynamicPage(path)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					comp.renderDynamicPage(io.Discard, nodes, vars)
+				}
+			})
+		})
+	}
+}
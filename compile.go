@@ -4,22 +4,24 @@ import (
 	"bytes"
 	"compress/gzip"
 	"errors"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	lorem "github.com/drhodes/golorem"
 	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
 	mdhtml "github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 	"github.com/tdewolff/minify/v2"
 	"github.com/tdewolff/minify/v2/html"
 	"github.com/tkdeng/goutil"
 	"github.com/tkdeng/regex"
-	"gopkg.in/yaml.v3"
 )
 
 var DebugCompiler = false
@@ -29,9 +31,53 @@ var modDevelopmentMode = false
 
 type compiler struct {
 	config *Config
+
+	// dynPages caches the parsed templates of @pages by their dist path
+	dynPages map[string][]*tempNode
+	dynMU    sync.RWMutex
+
+	// collections holds the {collections.name} vars of each locale
+	collections map[string]Data
+	colMU       sync.RWMutex
+
+	// taxonomies holds the {taxonomies.name} vars of each locale
+	taxonomies map[string]Data
+	taxMU      sync.RWMutex
+
+	// data holds the {data.name} vars, and dataDeps the pages that use each data file
+	data     Data
+	dataDeps map[string]map[string]bool
+	dataMU   sync.RWMutex
+
+	// sources holds the data sources registered with app.DataSource,
+	// and genPages the dist paths of the pages generated in each directory
+	sources  map[string]func() ([]Data, error)
+	genPages map[string][]string
+	srcMU    sync.RWMutex
+
+	// schedule holds the next time a page will be published or expire
+	schedule map[string]time.Time
+	schMU    sync.Mutex
+
+	// sitemap holds the compiled pages by their url, and is written after live changes (see updateSitemap)
+	sitemap  map[string]sitemapPage
+	mapTimer *time.Timer
+	mapMU    sync.Mutex
+	live     bool
+
+	// feeds holds the front matter of the directories with feeds
+	feeds  map[string]Data
+	feedMU sync.RWMutex
+
+	// errs collects include and math errors during the initial compile (nil after)
+	errs  []error
+	errMU sync.Mutex
 }
 
-func compile(appConfig *Config) *compiler {
+// compile compiles the app pages, theme, and plugins
+//
+// in strict mode, an error will be returned for missing or recursive includes and layouts, and invalid math
+func compile(appConfig *Config) (*compiler, error) {
 	initExample := false
 	if _, err := os.Stat(appConfig.Root); err != nil {
 		initExample = true
@@ -45,6 +91,8 @@ func compile(appConfig *Config) *compiler {
 
 	os.MkdirAll(appConfig.Root, 0755)
 	os.MkdirAll(appConfig.Root+"/pages", 0755)
+	os.MkdirAll(appConfig.Root+"/layouts", 0755)
+	os.MkdirAll(appConfig.Root+"/data", 0755)
 	os.MkdirAll(appConfig.Root+"/theme", 0755)
 	os.MkdirAll(appConfig.Root+"/assets", 0755)
 	os.MkdirAll(appConfig.Root+"/wasm", 0755)
@@ -109,12 +157,24 @@ func compile(appConfig *Config) *compiler {
 	PrintMsg("warn", "Compiling Server Pages...", 50, false)
 
 	comp := compiler{
-		config: appConfig,
+		config:   appConfig,
+		dynPages: map[string][]*tempNode{},
+		dataDeps: map[string]map[string]bool{},
+		sources:  map[string]func() ([]Data, error){},
+		genPages: map[string][]string{},
+		schedule: map[string]time.Time{},
+		sitemap:  map[string]sitemapPage{},
+		feeds:    map[string]Data{},
+		errs:     []error{},
 	}
 
 	comp.loadCSP()
+	comp.loadData()
+	comp.loadCollections()
+	comp.loadTaxonomies()
 
 	comp.compPages()
+	comp.writeSitemap()
 	comp.compileLive()
 
 	PrintMsg("warn", "Compiling Theme...", 50, false)
@@ -159,9 +219,19 @@ func compile(appConfig *Config) *compiler {
 
 	//todo: generate manifest.json (and auto generate icons) and allow config.yml file to modify
 
+	comp.errMU.Lock()
+	errs := comp.errs
+	comp.errs = nil
+	comp.errMU.Unlock()
+
+	if appConfig.Strict && len(errs) != 0 {
+		PrintMsg("error", "Failed To Compile Server!", 50, true)
+		return &comp, errors.Join(errs...)
+	}
+
 	PrintMsg("confirm", "Compiled Server!", 50, true)
 
-	return &comp
+	return &comp, nil
 }
 
 func (comp *compiler) compilePluginsLive() {
@@ -226,6 +296,10 @@ func (comp *compiler) compilePluginsLive() {
 }
 
 func (comp *compiler) compileLive() {
+	comp.mapMU.Lock()
+	comp.live = true
+	comp.mapMU.Unlock()
+
 	fw := goutil.FileWatcher()
 
 	fw.OnFileChange = func(path, op string) {
@@ -241,6 +315,20 @@ func (comp *compiler) compileLive() {
 		}
 
 		if strings.HasSuffix(path, ".html") || strings.HasSuffix(path, ".md") {
+			// pages with taxonomy terms are listed by the term pages
+			if comp.loadTaxonomies() {
+				comp.loadCollections()
+				comp.compPages()
+				return
+			}
+
+			// pages in a collection are listed by other pages
+			if comp.inCollection(path) {
+				comp.loadCollections()
+				comp.compPages()
+				return
+			}
+
 			path = filepath.Dir(path)
 
 			if path == "." || path == "" {
@@ -248,7 +336,8 @@ func (comp *compiler) compileLive() {
 				return
 			}
 
-			comp.compPages(path)
+			comp.compLocalePages(path)
+			comp.compParentFeeds(path)
 			return
 		}
 	}
@@ -259,7 +348,14 @@ func (comp *compiler) compileLive() {
 			return true
 		}
 
-		comp.compPages(path)
+		if comp.loadTaxonomies() || comp.inCollection(path) {
+			comp.loadCollections()
+			comp.compPages()
+			return true
+		}
+
+		comp.compLocalePages(path)
+		comp.compParentFeeds(path)
 		return true
 	}
 
@@ -276,6 +372,20 @@ func (comp *compiler) compileLive() {
 			return true
 		}
 
+		if strings.HasPrefix(filepath.Base(path), "@") {
+			comp.removeDynamicPage(path)
+		}
+
+		if comp.loadTaxonomies() || comp.inCollection(path) {
+			comp.loadCollections()
+			comp.compPages()
+
+			if dist, err := goutil.JoinPath(comp.config.Root+"/dist", path); err == nil && !strings.HasSuffix(path, ".html") && !strings.HasSuffix(path, ".md") {
+				comp.removeDir(dist)
+			}
+			return true
+		}
+
 		if strings.HasSuffix(path, ".html") || strings.HasSuffix(path, ".md") {
 			path = filepath.Dir(path)
 
@@ -284,68 +394,247 @@ func (comp *compiler) compileLive() {
 				return true
 			}
 
-			comp.compPages(path)
+			comp.compLocalePages(path)
+			comp.compParentFeeds(path)
 			return true
 		}
 
-		if dist, err := goutil.JoinPath(comp.config.Root+"/dist", path); err == nil {
-			os.Remove(dist + ".html")
-			os.RemoveAll(dist)
+		// translations of the removed page may still fall back to another locale
+		for _, uriPath := range comp.localePaths(path) {
+			if len(comp.pageDirs(uriPath)) != 0 {
+				comp.compPages(uriPath...)
+			} else if dist, err := goutil.JoinPath(comp.config.Root+"/dist", uriPath...); err == nil {
+				comp.removeDir(dist)
+			}
 		}
 
+		comp.compParentFeeds(path)
+
 		return true
 	}
 
 	fw.WatchDir(comp.config.Root + "/pages")
+
+	// recompile all pages when a layout changes
+	lfw := goutil.FileWatcher()
+
+	lfw.OnFileChange = func(path, op string) {
+		if strings.HasSuffix(path, ".html") {
+			comp.compPages()
+		}
+	}
+
+	lfw.OnRemove = func(path, op string) bool {
+		if strings.HasSuffix(path, ".html") {
+			comp.compPages()
+		}
+		return true
+	}
+
+	lfw.WatchDir(comp.config.Root + "/layouts")
+
+	// recompile the pages that use a data file when it changes
+	dfw := goutil.FileWatcher()
+
+	dataChange := func(path string) {
+		path, err := filepath.Rel(comp.config.Root+"/data", path)
+		if err != nil || strings.HasPrefix(path, "..") {
+			return
+		}
+
+		comp.loadData()
+
+		key, _, _ := strings.Cut(filepath.ToSlash(strings.TrimSuffix(path, filepath.Ext(path))), "/")
+		comp.compDataPages(key)
+	}
+
+	dfw.OnFileChange = func(path, op string) {
+		dataChange(path)
+	}
+
+	dfw.OnRemove = func(path, op string) bool {
+		dataChange(path)
+		return true
+	}
+
+	dfw.WatchDir(comp.config.Root + "/data")
 }
 
 func (comp *compiler) compPages(path ...string) {
-	dir, err := goutil.JoinPath(comp.config.Root+"/pages", path...)
-	if err != nil {
+	// the source directories of the page, from each locale tree
+	dirs := comp.pageDirs(path)
+	if len(dirs) == 0 {
 		return
 	}
+	dir := dirs[0]
 
 	dist, err := goutil.JoinPath(comp.config.Root+"/dist", path...)
 	if err != nil {
 		return
 	}
 
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return
+	locale, rest := comp.pageLocale(path)
+
+	// pages of other locales are only compiled from their own translations,
+	// and fall back to the page of the default locale when requested (see distURL)
+	translated := true
+	localeDir := ""
+	if locale != comp.defaultLocale() {
+		translated = comp.hasTranslation(locale, rest)
+		localeDir, _ = goutil.JoinPath(comp.config.Root+"/pages", path...)
 	}
 
-	dynPage := []string{}
+	subDirs := []string{}
+	dynPage := map[string]string{}
+	paramDir := ""
 
-	var wg sync.WaitGroup
-	for _, file := range files {
-		if file.IsDir() {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				comp.compPages(append(path, file.Name())...)
-			}()
-		} else if strings.HasPrefix(file.Name(), "@") {
-			dynPage = append(dynPage, file.Name())
+	for _, d := range dirs {
+		files, err := os.ReadDir(d)
+		if err != nil {
+			continue
 		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				// locale trees are compiled from the root of the site
+				if len(rest) == 0 && comp.isLocale(file.Name()) {
+					continue
+				}
+
+				if !goutil.Contains(subDirs, file.Name()) {
+					subDirs = append(subDirs, file.Name())
+				}
+			} else if localeDir != "" && d != localeDir {
+				continue
+			} else if strings.HasPrefix(file.Name(), "@") {
+				if _, ok := dynPage[file.Name()]; !ok {
+					dynPage[file.Name()] = d
+				}
+			} else if paramDir == "" && regex.Comp(regParamPage).Match([]byte(file.Name())) {
+				paramDir = d
+			}
+		}
+	}
+
+	if paramDir == "" {
+		paramDir = dir
+		if localeDir != "" {
+			paramDir = localeDir
+		}
+	}
+
+	// pages of the other locales have a locale prefix (i.e. /es/about)
+	if len(path) == 0 {
+		for _, locale := range comp.config.Locales {
+			if locale != comp.defaultLocale() {
+				subDirs = append(subDirs, locale)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range subDirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp.compPages(append(path[:len(path):len(path)], name)...)
+		}()
 	}
 	defer wg.Wait()
 
-	for _, page := range dynPage {
-		comp.precompDynamicPage(dir, dist, page, path)
+	for page, d := range dynPage {
+		comp.precompDynamicPage(d, dist, page, path)
+	}
+
+	comp.compParamPages(paramDir, dist, path)
+
+	if !translated {
+		comp.removeSitemapPage("/" + strings.Join(path, "/"))
+		comp.removePage(dist)
+		return
 	}
 
-	buf := goutil.CloneBytes(tempLayout)
+	buf := comp.loadLayout("")
 	configVars := comp.compPage(&buf, path)
-	comp.compVars(&buf, path, false, configVars)
 
+	// recompile with the layout selected by the page
+	if layout := varString(configVars["layout"]); layout != "" && layout != "default" {
+		buf = comp.loadLayout(layout)
+		configVars = comp.compPage(&buf, path)
+	}
+
+	pageDist := dist
 	if len(path) == 0 || dist == comp.config.Root+"/dist" {
-		dist += "/index"
+		pageDist += "/index"
 	}
+
+	url := "/" + strings.Join(path, "/")
+	src := []string{}
+	for _, d := range dirs {
+		src = append(src, d+"/head.html", d+"/head.md", d+"/body.html", d+"/body.md")
+		if locale != "" {
+			src = append(src, d+"/head."+locale+".html", d+"/head."+locale+".md", d+"/body."+locale+".html", d+"/body."+locale+".md")
+		}
+	}
+
+	// skip drafts, and pages that are not published yet or have expired
+	visible, at := comp.pageVisible(configVars, time.Now())
+	comp.schedulePage(strings.Join(path, "/"), at)
+
+	if !visible {
+		comp.removeSitemapPage(url)
+		comp.removePage(pageDist)
+		if dir, err := goutil.JoinPath(comp.config.Root+"/pages", append(path, "page")...); err == nil {
+			if _, err := os.Stat(dir); err != nil {
+				os.RemoveAll(dist + "/page")
+			}
+		}
+		return
+	}
+
+	comp.compFeeds(path, configVars)
+
+	// split collection listings into multiple pages
+	if pages := comp.paginate(locale, rest, configVars); len(pages) != 0 {
+		if dir, err := goutil.JoinPath(comp.config.Root+"/pages", append(path, "page")...); err == nil {
+			if _, err := os.Stat(dir); err != nil {
+				os.RemoveAll(dist + "/page")
+			}
+		}
+
+		for i, pageVars := range pages {
+			b := goutil.CloneBytes(buf)
+			comp.compVars(&b, path, false, pageVars)
+
+			if i == 0 {
+				comp.writePage(pageDist, b, pageVars)
+				comp.addSitemapPage(url, pageDist, pageVars, src...)
+			} else {
+				comp.writePage(dist+"/page/"+strconv.Itoa(i+1), b, pageVars)
+				comp.addSitemapPage(strings.TrimSuffix(url, "/")+"/page/"+strconv.Itoa(i+1), dist+"/page/"+strconv.Itoa(i+1), pageVars, src...)
+			}
+		}
+		return
+	}
+
+	comp.compVars(&buf, path, false, configVars)
+	comp.writePage(pageDist, buf, configVars)
+	comp.addSitemapPage(url, pageDist, configVars, src...)
+}
+
+// writePage writes a compiled page to the dist directory
+//
+// @dist: the path of the page, without the .html extension
+func (comp *compiler) writePage(dist string, buf []byte, configVars Data) {
 	dist += ".html"
 
+	// keep search engines from indexing the page
+	if isTruthy(configVars["noindex"]) {
+		buf = regex.Comp(`</head>`).RepLit(buf, []byte(`<meta name="robots" content="noindex"></head>`))
+	}
+
 	// check if CSP is enabled
-	if comp.config.cspText != "" && ((comp.config.CSP && configVars["csp"] != "no" && configVars["csp"] != "false") || configVars["csp"] == "yes" || configVars["csp"] == "true") {
+	if comp.config.cspText != "" && ((comp.config.CSP && (configVars["csp"] == nil || isTruthy(configVars["csp"]))) || isTruthy(configVars["csp"])) {
 		if regex.Comp(`'nonce(-.*?|)'`).Match([]byte(comp.config.csp.ScriptSrc)) {
 			buf = regex.Comp(`<script(\s.*?|)>`).RepFunc(buf, func(data func(int) []byte) []byte {
 				return regex.JoinBytes(`<script`, data(1), ` nonce="{nonce}"`, '>')
@@ -392,17 +681,147 @@ func (comp *compiler) compPages(path ...string) {
 	os.WriteFile(dist, buf, 0755)
 }
 
-func (comp *compiler) compPage(buf *[]byte, uriPath []string) Map {
+// removePage removes a compiled page from the dist directory, and its cached @page template
+//
+// @dist: the path of the page, without the .html extension
+func (comp *compiler) removePage(dist string) {
+	os.Remove(dist + ".html")
+	os.Remove(dist + ".html.gz")
+	os.Remove(string(regex.Comp(`\/([^\/]+)$`).Rep([]byte(dist), []byte("/#$1"))) + ".html")
+
+	comp.dynMU.Lock()
+	delete(comp.dynPages, dist+".html")
+	comp.dynMU.Unlock()
+}
+
+// removeDir removes a compiled page and its directory from the dist directory,
+// with the cached templates of the @pages in it
+func (comp *compiler) removeDir(dist string) {
+	comp.removePage(dist)
+	os.RemoveAll(dist)
+
+	comp.dynMU.Lock()
+	for path := range comp.dynPages {
+		if strings.HasPrefix(path, dist+"/") {
+			delete(comp.dynPages, path)
+		}
+	}
+	comp.dynMU.Unlock()
+}
+
+// removeDynamicPage removes a compiled @page (and its cached template) after its source file is removed
+//
+// @path: the source file, relative to the pages directory
+func (comp *compiler) removeDynamicPage(path string) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	for _, uriPath := range comp.localePaths(filepath.Dir(path)) {
+		if dist, err := goutil.JoinPath(comp.config.Root+"/dist", append(uriPath, name)...); err == nil {
+			comp.removePage(dist)
+		}
+	}
+}
+
+// IncludeError is a missing or recursive {@include} found while compiling pages
+type IncludeError struct {
+	// File is the file the include is in, relative to the app root
+	File string
+
+	// Line is the line of the include in File (0 if unknown)
+	Line int
+
+	// Include is the include tag (i.e. {@header})
+	Include string
+
+	// Chain is the list of files that include each other, if the include is recursive
+	Chain []string
+
+	// Outside is true if the include path leads outside of the pages directory
+	Outside bool
+}
+
+func (err *IncludeError) Error() string {
+	file := err.File
+	if err.Line != 0 {
+		file += ":" + strconv.Itoa(err.Line)
+	}
+
+	if len(err.Chain) != 0 {
+		return file + ": include cycle " + err.Include + ": " + strings.Join(err.Chain, " -> ")
+	} else if err.Outside {
+		return file + ": include outside of pages " + err.Include
+	}
+	return file + ": include not found " + err.Include
+}
+
+// regIncludeTag matches an {@include} with its args (i.e. {@card title="Pricing" featured})
+var regIncludeTag = `\{@([\w_\-\./]+)((?:\s+[\w_\-\.]+(?:=(?:"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|[^\s"'\}]+))?)*)\s*\}`
+
+// includeFile is a file in the include chain of a page
+type includeFile struct {
+	// path is relative to the app root (i.e. pages/about/body.md)
+	path string
+
+	// src is the original content of the file, used to find the line of an include
+	src []byte
+}
+
+// compPage embeds the {@includes} of a page
+//
+// @chain: the files that included this content, used to detect include cycles
+func (comp *compiler) compPage(buf *[]byte, uriPath []string, chain ...includeFile) Data {
 	*buf = bytes.TrimSpace(*buf)
 	*buf = goutil.CloneBytes(*buf)
 
-	config := Map{}
+	config := Data{}
+	locale, _ := comp.pageLocale(uriPath)
 
-	readFile := func(path string, uri []string, name string) ([]byte, error) {
+	file := includeFile{path: "layout"}
+	if len(chain) != 0 {
+		file = chain[len(chain)-1]
+	}
+
+	// tagLine returns the line of the next occurrence of an include in the current file
+	tagIndex := map[string]int{}
+	tagLine := func(tag []byte) int {
+		i := tagIndex[string(tag)]
+		j := bytes.Index(file.src[i:], tag)
+		if j == -1 {
+			return 0
+		}
+
+		tagIndex[string(tag)] = i + j + len(tag)
+		return bytes.Count(file.src[:i+j], []byte{'\n'}) + 1
+	}
+
+	// nextChain adds a file to the include chain, and returns false if the file is already in the chain
+	nextChain := func(path string, b []byte, inc *IncludeError) ([]includeFile, bool) {
+		if rel, err := filepath.Rel(comp.config.Root, path); err == nil {
+			path = rel
+		}
+
+		for i, f := range chain {
+			if f.path == path {
+				list := []string{}
+				for _, f := range chain[i:] {
+					list = append(list, f.path)
+				}
+
+				inc.Chain = append(list, path)
+				comp.includeErr(inc)
+				return nil, false
+			}
+		}
+
+		return append(chain[:len(chain):len(chain)], includeFile{path: path, src: goutil.CloneBytes(b)}), true
+	}
+
+	readFile := func(path string, uri []string, name string, args Map, inc *IncludeError) ([]byte, error) {
 		var b []byte
 		var err error = errors.New("file not found")
 
 		isMD := false
+		filePath := ""
 
 		// embed parent #page.html files
 		if strings.Join(uri, "/") != strings.Join(uriPath, "/") {
@@ -410,24 +829,41 @@ func (comp *compiler) compPage(buf *[]byte, uriPath []string) Map {
 
 			if err != nil {
 				isMD = false
-				b, err = os.ReadFile(cPath + ".html")
+				filePath = cPath + ".html"
+				b, err = os.ReadFile(filePath)
 			}
 
 			if err != nil {
 				isMD = true
-				b, err = os.ReadFile(cPath + ".md")
+				filePath = cPath + ".md"
+				b, err = os.ReadFile(filePath)
 			}
 		}
 
+		// embed translated page files (i.e. body.es.md)
+		if err != nil && locale != "" {
+			isMD = false
+			filePath = path + "." + locale + ".html"
+			b, err = os.ReadFile(filePath)
+		}
+
+		if err != nil && locale != "" {
+			isMD = true
+			filePath = path + "." + locale + ".md"
+			b, err = os.ReadFile(filePath)
+		}
+
 		// embed regular page files
 		if err != nil {
 			isMD = false
-			b, err = os.ReadFile(path + ".html")
+			filePath = path + ".html"
+			b, err = os.ReadFile(filePath)
 		}
 
 		if err != nil {
 			isMD = true
-			b, err = os.ReadFile(path + ".md")
+			filePath = path + ".md"
+			b, err = os.ReadFile(filePath)
 		}
 
 		// embed @widgets
@@ -448,11 +884,18 @@ func (comp *compiler) compPage(buf *[]byte, uriPath []string) Map {
 			if hasDyn {
 				b, err = os.ReadFile(dPath)
 				if err == nil {
+					next, ok := nextChain(dPath, b, inc)
+					if !ok {
+						return []byte{}, nil
+					}
+
 					if isMD {
-						comp.compileMD(&b)
+						comp.compileMD(&b, dPath)
 					}
 
-					configVars := comp.compPage(&b, uriPath)
+					comp.compIncludeArgs(&b, args)
+
+					configVars := comp.compPage(&b, uriPath, next...)
 					comp.compVars(&b, uriPath, false, configVars)
 					return b, nil
 				}
@@ -465,44 +908,96 @@ func (comp *compiler) compPage(buf *[]byte, uriPath []string) Map {
 			return []byte{}, err
 		}
 
-		// get config from file
-		b = regex.Comp(`(?s)^---\r?\n(.*?)\r?\n---\r?\n`).RepFunc(b, func(data func(int) []byte) []byte {
-			b := regex.Comp(`(?m)^(\s*(?:-\s+|))([\w_\-]+):`).RepFunc(data(1), func(data func(int) []byte) []byte {
-				return regex.JoinBytes(data(1), bytes.ReplaceAll(bytes.ReplaceAll(bytes.ToLower(data(2)), []byte{'-'}, []byte{}), []byte{'_'}, []byte{}), ':')
-			})
-			yaml.Unmarshal(b, &config)
+		next, ok := nextChain(filePath, b, inc)
+		if !ok {
+			return []byte{}, nil
+		}
 
-			return []byte{}
-		})
+		// get config from file
+		parseFrontMatter(&b, config)
 
 		if isMD {
-			comp.compileMD(&b)
+			comp.compileMD(&b, filePath)
 		}
 
-		comp.compPage(&b, uriPath)
+		comp.compIncludeArgs(&b, args)
+
+		comp.compPage(&b, uriPath, next...)
 		return b, nil
 	}
 
-	*buf = regex.Comp(`\{@([\w_\-\.]+)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
+	*buf = regex.Comp(regIncludeTag+regLayoutMark+`?`).RepFunc(*buf, func(data func(int) []byte) []byte {
+		args := parseIncludeArgs(data(2))
+
+		// includes from a layout are marked with the layout file and line (see markLayoutIncludes)
+		tag := data(0)
+		inLayout := len(data(3)) != 0
+		if inLayout {
+			tag = tag[:len(tag)-len(data(3))-2]
+		}
+
+		inc := &IncludeError{
+			File:    file.path,
+			Include: string(tag),
+		}
+
+		if inLayout {
+			path, line, _ := strings.Cut(string(data(3)), "\x00")
+			inc.File = path
+			inc.Line, _ = strconv.Atoi(line)
+		} else {
+			inc.Line = tagLine(tag)
+		}
+
+		// absolute {@/shared/footer} and relative {@../common/nav} paths
+		if name := string(data(1)); name[0] == '/' || strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+			if name[0] != '/' {
+				// relative to the directory of the current file
+				dir := filepath.Join(uriPath...)
+				if rel, err := filepath.Rel("pages", file.path); err == nil && !strings.HasPrefix(rel, "..") {
+					dir = filepath.Dir(rel)
+				}
+				name = filepath.Join(dir, name)
+			}
+
+			path, err := goutil.JoinPath(comp.config.Root+"/pages", name)
+			if err != nil {
+				inc.Outside = true
+				comp.includeErr(inc)
+				return []byte{}
+			}
+
+			if b, err := readFile(path, uriPath, filepath.Base(path), args, inc); err == nil {
+				return b
+			}
+
+			comp.includeErr(inc)
+			return []byte{}
+		}
+
 		uri := uriPath
-		for len(uri) != 0 {
-			if dir, err := goutil.JoinPath(comp.config.Root+"/pages", uri...); err == nil {
+		for {
+			// the locale trees of the page, so missing translations use the default locale
+			for _, dir := range comp.pageDirs(uri) {
 				if path, err := goutil.JoinPath(dir, string(data(1))); err == nil {
-					if b, err := readFile(path, uri, string(data(1))); err == nil {
+					if b, err := readFile(path, uri, string(data(1)), args, inc); err == nil {
 						return b
 					}
-
-					uri = uri[:len(uri)-1]
 				}
 			}
-		}
 
-		if path, err := goutil.JoinPath(comp.config.Root+"/pages", string(data(1))); err == nil {
-			if b, err := readFile(path, uri, string(data(1))); err == nil {
-				return b
+			if len(uri) == 0 {
+				break
 			}
+			uri = uri[:len(uri)-1]
 		}
 
+		// {@head} and {@body} are optional in layouts
+		if inLayout && (string(data(1)) == "head" || string(data(1)) == "body") {
+			return []byte{}
+		}
+
+		comp.includeErr(inc)
 		return []byte{}
 	})
 
@@ -511,16 +1006,80 @@ func (comp *compiler) compPage(buf *[]byte, uriPath []string) Map {
 	return config
 }
 
-func (comp *compiler) compVars(buf *[]byte, uriPath []string, dynamic bool, configVars Map) {
+// includeErr reports a missing or recursive include
+func (comp *compiler) includeErr(err *IncludeError) {
+	comp.compileErr(err)
+}
+
+// compileErr reports an error found while compiling pages (like a missing include, or invalid math)
+//
+// in strict mode, the error will also be returned by the initial compile
+func (comp *compiler) compileErr(err error) {
+	comp.errMU.Lock()
+	defer comp.errMU.Unlock()
+
+	// pages with a layout are compiled twice, so errors are only reported once
+	for _, e := range comp.errs {
+		if e.Error() == err.Error() {
+			return
+		}
+	}
+
+	PrintMsg("error", err.Error(), 50, true)
+
+	if comp.errs != nil {
+		comp.errs = append(comp.errs, err)
+	}
+}
+
+// parseIncludeArgs parses the arguments of an include
+//
+// i.e. {@card title="Pricing" href='/pricing' size=lg featured}
+func parseIncludeArgs(buf []byte) Map {
+	args := Map{}
+
+	regex.Comp(`([\w_\-\.]+)(?:=(?:"((?:\\.|[^"\\])*)"|'((?:\\.|[^'\\])*)'|([^\s"'\}]+)))?`).RepFunc(buf, func(data func(int) []byte) []byte {
+		if len(data(0)) == len(data(1)) {
+			args[string(data(1))] = "true"
+		} else {
+			args[string(data(1))] = string(regex.Comp(`\\(.)`).Rep(regex.JoinBytes(data(2), data(3), data(4)), []byte("$1")))
+		}
+		return nil
+	})
+
+	return args
+}
+
+// compIncludeArgs embeds include arguments as variables in the included file only
+func (comp *compiler) compIncludeArgs(buf *[]byte, args Map) {
+	if len(args) == 0 {
+		return
+	}
+
+	*buf = comp.renderTemp(parseTemp(*buf), lookupVars(args), true)
+}
+
+func (comp *compiler) compVars(buf *[]byte, uriPath []string, dynamic bool, configVars Data) {
+	// the url of a translation has a locale prefix (i.e. /es/about)
+	url := uriPath
+	_, uriPath = comp.pageLocale(uriPath)
+
+	colVars := comp.collectionVars(url)
+	taxVars := comp.taxonomyVars(url)
+	dataVars := dataVars{comp: comp, page: strings.Join(uriPath, "/")}
+	pageVars := Data{"page": configVars}
+	localeVars := comp.localeVars(url)
+	lookup := lookupVars(configVars, comp.config.Vars, colVars, taxVars, dataVars, pageVars, localeVars)
+
 	if !dynamic {
 		name := ""
 		if len(uriPath) > 0 {
 			name = capWords(uriPath[len(uriPath)-1])
 		}
-		comp.compTitleVars(buf, name, configVars)
+		lookup = lookupVars(configVars, comp.config.Vars, colVars, taxVars, dataVars, pageVars, localeVars, comp.titleVars(name, lookup))
 	}
 
-	*buf = regex.Comp(`\{#?uri\}`).RepLit(*buf, EscapeHTML([]byte(strings.Join(uriPath, "/"))))
+	*buf = regex.Comp(`\{#?uri\}`).RepLit(*buf, EscapeHTML([]byte(strings.Join(url, "/"))))
 
 	if len(uriPath) > 1 {
 		*buf = regex.Comp(`\{#?parent\}`).RepLit(*buf, EscapeHTML([]byte(uriPath[len(uriPath)-2])))
@@ -544,145 +1103,159 @@ func (comp *compiler) compVars(buf *[]byte, uriPath []string, dynamic bool, conf
 
 	if !dynamic {
 		comp.compRandVars(buf)
-	}
 
-	*buf = regex.Comp(`\{(#|)([\w_\-\.]+)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		if val, ok := configVars[string(data(2))]; ok {
-			if len(data(1)) != 0 {
-				return []byte(val)
+		tocLookup := lookupVars(Data{"toc": comp.compTOC(buf, configVars, lookup)})
+		pageLookup := lookup
+		lookup = func(name string) (any, bool) {
+			if val, ok := tocLookup(name); ok {
+				return val, true
 			}
-
-			//todo: detect if inside html arg
-			return EscapeHTML([]byte(val))
-		} else if val, ok := comp.config.Vars[string(data(2))]; ok {
-			if len(data(1)) != 0 {
-				return []byte(val)
-			}
-
-			//todo: detect if inside html arg
-			return EscapeHTML([]byte(val))
+			return pageLookup(name)
 		}
+	}
 
-		if dynamic {
-			return data(0)
-		}
-		return []byte{}
-	})
+	*buf = comp.renderTemp(parseTemp(*buf), lookup, dynamic)
 }
 
-func (comp *compiler) compTitleVars(buf *[]byte, name string, configVars Map) {
-	*buf = regex.Comp(`\{#?sitetitle\}`).RepLit(*buf, EscapeHTML([]byte(comp.config.Title)))
+// titleVars returns the default {title}, {sitetitle}, {app}, {desc}, and {icon} vars
+func (comp *compiler) titleVars(name string, lookup func(name string) (any, bool)) Map {
+	vars := Map{
+		"sitetitle": comp.config.Title,
+		"title":     comp.config.Title,
+		"app":       comp.config.AppTitle,
+		"desc":      comp.config.Desc,
+		"icon":      comp.config.Icon,
+	}
 
-	if val, ok := configVars["title"]; ok {
-		*buf = regex.Comp(`\{#?title\}`).RepLit(*buf, EscapeHTML([]byte(val)))
+	if val, ok := lookup("title"); ok {
+		vars["title"] = varString(val)
 	} else if name != "" {
-		*buf = regex.Comp(`\{#?title\}`).RepLit(*buf, EscapeHTML([]byte(name+" | "+comp.config.Title)))
-	} else {
-		*buf = regex.Comp(`\{#?title\}`).RepLit(*buf, EscapeHTML([]byte(comp.config.Title)))
+		vars["title"] = name + " | " + comp.config.Title
 	}
 
-	if val, ok := configVars["app"]; ok {
-		*buf = regex.Comp(`\{#?app\}`).RepLit(*buf, EscapeHTML([]byte(val)))
-	} else if val, ok := configVars["apptitle"]; ok {
-		*buf = regex.Comp(`\{#?app\}`).RepLit(*buf, EscapeHTML([]byte(val)))
-	} else {
-		*buf = regex.Comp(`\{#?app\}`).RepLit(*buf, EscapeHTML([]byte(comp.config.AppTitle)))
+	if val, ok := lookup("app"); ok {
+		vars["app"] = varString(val)
+	} else if val, ok := lookup("apptitle"); ok {
+		vars["app"] = varString(val)
 	}
 
-	if val, ok := configVars["desc"]; ok {
-		*buf = regex.Comp(`\{#?desc\}`).RepLit(*buf, EscapeHTML([]byte(val)))
-	} else if val, ok := configVars["description"]; ok {
-		*buf = regex.Comp(`\{#?desc\}`).RepLit(*buf, EscapeHTML([]byte(val)))
-	} else {
-		*buf = regex.Comp(`\{#?desc\}`).RepLit(*buf, EscapeHTML([]byte(comp.config.Desc)))
+	if val, ok := lookup("desc"); ok {
+		vars["desc"] = varString(val)
+	} else if val, ok := lookup("description"); ok {
+		vars["desc"] = varString(val)
 	}
 
-	if val, ok := configVars["icon"]; ok {
-		*buf = regex.Comp(`\{#?icon\}`).RepLit(*buf, EscapeHTML([]byte(val)))
-	} else {
-		*buf = regex.Comp(`\{#?icon\}`).RepLit(*buf, EscapeHTML([]byte(comp.config.Icon)))
+	if val, ok := lookup("icon"); ok {
+		vars["icon"] = varString(val)
 	}
+
+	return vars
 }
 
+// regRandTag matches {rand}, {urand}, {randint}, and {lorem} vars
+var regRandTag = `\{#?(?:(?:u?rand|randint)\s*[0-9]*|(?:lorem|rand)(?:text|)\s*(?:[pswehu][a-z]*|)\s*[0-9]*(?:[^0-9][0-9]+|))\}`
+
 func (comp *compiler) compRandVars(buf *[]byte) {
-	*buf = regex.Comp(`\{#?rand\s*([0-9]*)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		size := uint(16)
-		if len(data(1)) > 0 {
-			if s, e := strconv.ParseUint(string(data(1)), 10, 0); e == nil && s > 0 {
-				size = uint(s)
-			}
+	urand := [][]byte{}
+	*buf = regex.Comp(regRandTag).RepFunc(*buf, func(data func(int) []byte) []byte {
+		if tag, ok := parseRandTag(data(0)); ok {
+			return tag.gen(&urand)
 		}
-
-		return goutil.RandBytes(size)
+		return data(0)
 	})
+}
 
-	urand := [][]byte{}
-	*buf = regex.Comp(`\{#?urand\s*([0-9]*)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		size := uint(16)
-		if len(data(1)) > 0 {
-			if s, e := strconv.ParseUint(string(data(1)), 10, 0); e == nil && s > 0 {
-				size = uint(s)
-			}
-		}
+// randTag is a parsed {rand}, {urand}, {randint}, or {lorem} var
+type randTag struct {
+	// kind is 'r' (rand), 'u' (urand), 'i' (randint), or 'l' (lorem)
+	kind byte
+	size int
 
-		return goutil.URandBytes(size, &urand)
-	})
+	// lorem is the type of lorem text (p, s, w, e, h, or u)
+	lorem byte
+	min   int
+	max   int
+}
+
+// parseRandTag parses the arguments of a {rand}, {urand}, {randint}, or {lorem} var
+func parseRandTag(tag []byte) (randTag, bool) {
+	if m := regex.Comp(`^\{#?(rand|urand|randint)\s*([0-9]*)\}$`).RE.FindSubmatch(tag); m != nil {
+		rt := randTag{kind: 'r', size: 16}
+		if string(m[1]) == "urand" {
+			rt.kind = 'u'
+		} else if string(m[1]) == "randint" {
+			rt.kind = 'i'
+			rt.size = 10
+		}
 
-	*buf = regex.Comp(`\{#?randint\s*([0-9]*)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		size := 10
-		if len(data(1)) > 0 {
-			if s, e := strconv.Atoi(string(data(1))); e == nil && s > 0 {
-				size = s
+		if len(m[2]) > 0 {
+			if s, e := strconv.Atoi(string(m[2])); e == nil && s > 0 {
+				rt.size = s
 			}
 		}
 
-		return []byte(strconv.Itoa(rand.Intn(size)))
-	})
+		return rt, true
+	}
 
-	*buf = regex.Comp(`\{#?(?:lorem|rand)(?:text|)\s*([pswehu][a-z]*|)\s*([0-9]*)([^0-9][0-9]+|)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		t := byte('p')
-		min := 3
-		max := 5
+	m := regex.Comp(`^\{#?(?:lorem|rand)(?:text|)\s*([pswehu][a-z]*|)\s*([0-9]*)([^0-9][0-9]+|)\}$`).RE.FindSubmatch(tag)
+	if m == nil {
+		return randTag{}, false
+	}
 
-		if len(data(1)) != 0 {
-			t = data(1)[0]
-		}
+	rt := randTag{kind: 'l', lorem: 'p', min: 3, max: 5}
 
-		if len(data(2)) != 0 {
-			if s, e := strconv.Atoi(string(data(2))); e == nil && s > 0 {
-				min = s
-			}
-		}
+	if len(m[1]) != 0 {
+		rt.lorem = m[1][0]
+	}
 
-		if len(data(3)) != 0 {
-			if s, e := strconv.Atoi(string(data(3)[1:])); e == nil && s > 0 {
-				max = s
-			}
-		} else if len(data(2)) != 0 {
-			max = min
+	if len(m[2]) != 0 {
+		if s, e := strconv.Atoi(string(m[2])); e == nil && s > 0 {
+			rt.min = s
 		}
+	}
 
-		if min > max {
-			min, max = max, min
+	if len(m[3]) != 0 {
+		if s, e := strconv.Atoi(string(m[3][1:])); e == nil && s > 0 {
+			rt.max = s
 		}
+	} else if len(m[2]) != 0 {
+		rt.max = rt.min
+	}
 
-		switch t {
-		case 'p':
-			return []byte(lorem.Paragraph(min, max))
-		case 's':
-			return []byte(lorem.Sentence(min, max))
-		case 'w':
-			return []byte(lorem.Word(min, max))
-		case 'e':
-			return []byte(lorem.Email())
-		case 'h':
-			return []byte(lorem.Host())
-		case 'u':
-			return []byte(lorem.Url())
-		default:
-			return []byte(lorem.Paragraph(min, max))
-		}
-	})
+	if rt.min > rt.max {
+		rt.min, rt.max = rt.max, rt.min
+	}
+
+	return rt, true
+}
+
+// gen generates a new random value
+//
+// @urand: the previous {urand} values of the page, to keep them unique
+func (rt randTag) gen(urand *[][]byte) []byte {
+	switch rt.kind {
+	case 'r':
+		return goutil.RandBytes(uint(rt.size))
+	case 'u':
+		return goutil.URandBytes(uint(rt.size), urand)
+	case 'i':
+		return []byte(strconv.Itoa(rand.Intn(rt.size)))
+	}
+
+	switch rt.lorem {
+	case 's':
+		return []byte(lorem.Sentence(rt.min, rt.max))
+	case 'w':
+		return []byte(lorem.Word(rt.min, rt.max))
+	case 'e':
+		return []byte(lorem.Email())
+	case 'h':
+		return []byte(lorem.Host())
+	case 'u':
+		return []byte(lorem.Url())
+	default:
+		return []byte(lorem.Paragraph(rt.min, rt.max))
+	}
 }
 
 func (comp *compiler) precompDynamicPage(dir, dist string, page string, uriPath []string) {
@@ -701,31 +1274,64 @@ func (comp *compiler) precompDynamicPage(dir, dist string, page string, uriPath
 		return
 	}
 
-	buf := goutil.CloneBytes(tempLayout)
-	buf = regex.Comp(`\{@body\}`).Rep(buf, b)
-	configVars := comp.compPage(&buf, uriPath)
+	pageVars := Data{}
+	parseFrontMatter(&b, pageVars)
+
+	buf := comp.loadLayout(varString(pageVars["layout"]))
+	buf = regex.Comp(`\{@body\}`+regLayoutMark+`?`).RepLit(buf, b)
+	rel, err := filepath.Rel(comp.config.Root, path)
+	if err != nil {
+		rel = path
+	}
+
+	configVars := comp.compPage(&buf, uriPath, includeFile{path: rel, src: b})
+	for key, val := range pageVars {
+		configVars[key] = val
+	}
 	comp.compVars(&buf, uriPath, true, configVars)
 
 	os.MkdirAll(dist, 0755)
 	os.WriteFile(out, buf, 0755)
+
+	// cache the parsed template, so requests only need to render it
+	comp.dynMU.Lock()
+	comp.dynPages[out] = parseTemp(buf)
+	comp.dynMU.Unlock()
 }
 
-func (comp *compiler) compileDynamicPage(buf *[]byte, vars Map) {
-	comp.compTitleVars(buf, "", vars)
-	comp.compRandVars(buf)
+// dynamicPage returns the parsed template of a precompiled @page
+//
+// the template is parsed once, and then cached for future requests
+func (comp *compiler) dynamicPage(path string) ([]*tempNode, error) {
+	comp.dynMU.RLock()
+	nodes, ok := comp.dynPages[path]
+	comp.dynMU.RUnlock()
 
-	*buf = regex.Comp(`\{(#|)([\w_\-\.]+)\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
-		if val, ok := vars[string(data(2))]; ok {
-			if len(data(1)) != 0 {
-				return []byte(val)
-			}
+	if ok {
+		return nodes, nil
+	}
 
-			//todo: detect if inside html arg
-			return EscapeHTML([]byte(val))
-		}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		return []byte{}
-	})
+	nodes = parseTemp(buf)
+
+	comp.dynMU.Lock()
+	comp.dynPages[path] = nodes
+	comp.dynMU.Unlock()
+
+	return nodes, nil
+}
+
+// renderDynamicPage renders a precompiled @page in a single pass
+func (comp *compiler) renderDynamicPage(w io.Writer, nodes []*tempNode, vars ...Vars) {
+	titleVars := comp.titleVars("", lookupVars(vars...))
+	lookup := lookupVars(append(vars[:len(vars):len(vars)], titleVars)...)
+
+	tw := tempWriter{w: w, comp: comp, request: true}
+	tw.write(nodes, lookup)
 }
 
 func (comp *compiler) compileHTML(buf *[]byte) {
@@ -749,21 +1355,159 @@ func (comp *compiler) compileHTML(buf *[]byte) {
 	}
 }
 
-func (comp *compiler) compileMD(buf *[]byte) {
+// compileMD compiles a markdown file, and reports invalid math with its line in the file
+func (comp *compiler) compileMD(buf *[]byte, path string) {
+	errs := comp.compileMarkdown(buf, TrustedMode)
+	if len(errs) == 0 || path == "" {
+		return
+	}
+
+	src, _ := os.ReadFile(path)
+	if rel, err := filepath.Rel(comp.config.Root, path); err == nil {
+		path = rel
+	}
+
+	last := 0
+	for _, err := range errs {
+		err.File = path
+
+		// find the first line of the math, after the previous error
+		line, _, _ := strings.Cut(strings.TrimSpace(err.Math), "\n")
+		if i := bytes.Index(src[last:], []byte(line)); i != -1 && line != "" {
+			err.Line = bytes.Count(src[:last+i], []byte{'\n'}) + 1
+			last += i + len(line)
+		}
+
+		comp.compileErr(err)
+	}
+}
+
+// compileMarkdown compiles markdown to html, and returns the math that is not valid LaTeX
+//
+// in SafeMode, template tags are left as text, and the html is sanitized
+func (comp *compiler) compileMarkdown(buf *[]byte, mode MarkdownMode) []*MathError {
+	// protect template tags (and their quoted args) from markdown
+	tags := [][]byte{}
+	if mode != SafeMode {
+		*buf = regex.Comp(`\{[@#?!*/:]?[\w_\-\./][^\{\}\r\n]*\}`).RepFunc(*buf, func(data func(int) []byte) []byte {
+			tags = append(tags, goutil.CloneBytes(data(0)))
+			return regex.JoinBytes("webxtag", len(tags)-1, "x")
+		})
+	}
+
+	restoreTags := func(buf []byte) []byte {
+		return regex.Comp(`webxtag([0-9]+)x`).RepFunc(buf, func(data func(int) []byte) []byte {
+			if i, err := strconv.Atoi(string(data(1))); err == nil && i < len(tags) {
+				return tags[i]
+			}
+			return data(0)
+		})
+	}
+
+	md := comp.mdConfig()
+
 	// create markdown parser with extensions
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
-	p := parser.NewWithExtensions(extensions)
+	p := parser.NewWithExtensions(md.extensions())
 	doc := p.Parse(*buf)
 
+	mdOffsetHeadings(doc, md.HeadingOffset)
+
+	callouts := map[ast.Node]callout{}
+	if md.Callouts == nil || *md.Callouts {
+		callouts = mdCallouts(doc)
+	}
+
 	// create HTML renderer with extensions
-	htmlFlags := mdhtml.CommonFlags | mdhtml.HrefTargetBlank
-	opts := mdhtml.RendererOptions{
-		Flags:           htmlFlags,
-		HeadingIDPrefix: "h-",
+	opts := md.rendererOptions()
+	if mode == SafeMode {
+		opts.HeadingIDPrefix = "user-content-" + opts.HeadingIDPrefix
+		opts.FootnoteAnchorPrefix = "user-content-"
+		opts.Flags |= mdhtml.NofollowLinks | mdhtml.NoopenerLinks
+	}
+
+	// in SafeMode, the MathML is added back to the text of the sanitized html,
+	// with a random placeholder that untrusted markdown can not guess
+	mathErrs := []*MathError{}
+	mathML := [][]byte{}
+	mathKey := []byte{}
+	if mode == SafeMode {
+		mathKey = goutil.RandBytes(16, nil)
+	}
+	writeMath := func(w io.Writer, literal []byte, display bool) {
+		tex := string(restoreTags(literal))
+		b, err := renderMath(tex, display)
+		if err != nil {
+			mathErrs = append(mathErrs, &MathError{Math: tex, Display: display, Err: err})
+		}
+
+		if mode == SafeMode {
+			mathML = append(mathML, b)
+			b = regex.JoinBytes("webxmath", mathKey, '_', len(mathML)-1, "x")
+		}
+		w.Write(b)
 	}
-	renderer := mdhtml.NewRenderer(opts)
+
+	var renderer *mdhtml.Renderer
+	opts.RenderNodeHook = func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		// highlight code blocks
+		if code, ok := node.(*ast.CodeBlock); ok {
+			w.Write(codeBlock(string(restoreTags(code.Info)), string(code.Literal)))
+			return ast.GoToNext, true
+		}
+
+		// convert LaTeX math to MathML
+		if math, ok := node.(*ast.Math); ok {
+			writeMath(w, math.Literal, false)
+			return ast.GoToNext, true
+		} else if math, ok := node.(*ast.MathBlock); ok {
+			if entering {
+				renderer.CR(w)
+				writeMath(w, math.Literal, true)
+				renderer.CR(w)
+			}
+			return ast.GoToNext, true
+		}
+
+		if c, ok := callouts[node]; ok {
+			writeCallout(renderer, w, c, entering)
+			return ast.GoToNext, true
+		}
+
+		return ast.GoToNext, false
+	}
+	renderer = mdhtml.NewRenderer(opts)
 
 	*buf = markdown.Render(doc, renderer)
+
+	if mode == SafeMode {
+		*buf = sanitizeHTML(*buf, func(text []byte) []byte {
+			if len(mathML) == 0 {
+				return text
+			}
+			return regex.Comp(`webxmath([\w\-]+)_([0-9]+)x`).RepFunc(text, func(data func(int) []byte) []byte {
+				if i, err := strconv.Atoi(string(data(2))); err == nil && i < len(mathML) && bytes.Equal(data(1), mathKey) {
+					return mathML[i]
+				}
+				return data(0)
+			})
+		})
+		return mathErrs
+	}
+
+	// heading ids use the name of a template var, rather than the template tag
+	*buf = regex.Comp(`(\sid="[^"]*)`).RepFunc(*buf, func(data func(int) []byte) []byte {
+		return regex.Comp(`webxtag([0-9]+)x`).RepFunc(data(1), func(data func(int) []byte) []byte {
+			if i, err := strconv.Atoi(string(data(1))); err == nil && i < len(tags) {
+				if m := regex.Comp(`^\{[@#?!*/:]?([\w_\-\.]+)`).RE.FindSubmatch(tags[i]); m != nil {
+					return bytes.ToLower(m[1])
+				}
+			}
+			return []byte{}
+		})
+	})
+
+	*buf = restoreTags(*buf)
+	return mathErrs
 }
 
 func (comp *compiler) loadCSP() {
@@ -0,0 +1,1077 @@
+package webx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncludeErrors(t *testing.T) {
+	DebugCompiler = true
+	defer func() { DebugCompiler = false }()
+
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages", 0755)
+	os.WriteFile(root+"/pages/body.html", []byte("<p>body</p>\n{@missing}\n{@a}\n{@missing}"), 0755)
+	os.WriteFile(root+"/pages/a.html", []byte("<p>a</p>{@b}"), 0755)
+	os.WriteFile(root+"/pages/b.html", []byte("<p>b</p>\n{@a}"), 0755)
+
+	os.MkdirAll(root+"/layouts", 0755)
+	os.WriteFile(root+"/layouts/docs.html", []byte("---\nlayout: default\n---\n{+body}\n<main>{@body}</main>\n{@sidebar}{/body}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true}, errs: []error{}}
+
+	buf := markLayoutIncludes([]byte(`{@head}{@body}`), "layout")
+	comp.compPage(&buf, []string{})
+
+	if string(buf) != "<p>body</p>\n<p>a</p><p>b</p>" {
+		t.Errorf("unexpected output %q", buf)
+	}
+
+	expected := []string{
+		"pages/body.html:2: include not found {@missing}",
+		"pages/b.html:2: include cycle {@a}: pages/a.html -> pages/b.html -> pages/a.html",
+		"pages/body.html:4: include not found {@missing}",
+	}
+
+	if len(comp.errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), comp.errs)
+	}
+
+	for i, err := range comp.errs {
+		var inc *IncludeError
+		if !errors.As(err, &inc) || err.Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], err)
+		}
+	}
+
+	// includes in a layout are reported with the layout file, and are not confused with the includes of the page
+	comp.errs = []error{}
+	buf = comp.loadLayout("docs")
+	comp.compPage(&buf, []string{})
+
+	if len(comp.errs) != 4 || comp.errs[3].Error() != "layouts/docs.html:6: include not found {@sidebar}" || bytes.Contains(buf, []byte{0}) {
+		t.Errorf("expected a layout include error, got %v in %q", comp.errs, buf)
+	}
+}
+
+func TestLayoutBlocks(t *testing.T) {
+	base := []byte(`<body class="{+class}base{/class}">{+nav}<nav/>{/nav}{+main}{?user}{user}{/user}{/main}</body>`)
+
+	blocks := map[string][]byte{}
+	layoutBlocks([]byte(`ignored {+class}docs{/class}{+main}<main>{+aside}{/aside}</main>{/main}`), func(name string, content []byte) {
+		blocks[name] = content
+	})
+
+	tests := []struct {
+		out    string
+		blocks map[string][]byte
+		keep   bool
+	}{
+		{`<body class="base"><nav/>{?user}{user}{/user}</body>`, nil, false},
+		{`<body class="docs"><nav/><main></main></body>`, blocks, false},
+		{`<body class="{+class}docs{/class}">{+nav}<nav/>{/nav}{+main}<main>{+aside}{/aside}</main>{/main}</body>`, blocks, true},
+		{`<body class="base"><nav/><main><aside/></main></body>`, map[string][]byte{"main": blocks["main"], "aside": []byte("<aside/>")}, false},
+	}
+
+	for _, test := range tests {
+		if out := string(fillLayoutBlocks(base, test.blocks, test.keep)); out != test.out {
+			t.Errorf("expected %q, got %q", test.out, out)
+		}
+	}
+}
+
+func TestLayoutErrors(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages", 0755)
+	os.MkdirAll(root+"/layouts", 0755)
+	os.WriteFile(root+"/pages/layout.html", []byte("---\nlayout: gone\n---\n<html>{@body}</html>"), 0755)
+	os.WriteFile(root+"/layouts/a.html", []byte("---\nlayout: b\n---\n"), 0755)
+	os.WriteFile(root+"/layouts/b.html", []byte("---\nlayout: a\n---\n"), 0755)
+
+	comp := &compiler{config: &Config{Root: root}, errs: []error{}}
+	comp.loadLayout("missing")
+	comp.loadLayout("a")
+	comp.loadLayout("")
+
+	expected := []string{
+		"layouts/missing.html: layout not found",
+		"layouts/gone.html: layout not found",
+		"layouts/b.html: layout cycle: layouts/a.html -> layouts/b.html -> layouts/a.html",
+	}
+
+	if len(comp.errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), comp.errs)
+	}
+
+	for i, err := range comp.errs {
+		var layoutErr *LayoutError
+		if !errors.As(err, &layoutErr) || err.Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], err)
+		}
+	}
+}
+
+func TestIncludePaths(t *testing.T) {
+	DebugCompiler = true
+	defer func() { DebugCompiler = false }()
+
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/blog/post", 0755)
+	os.MkdirAll(root+"/pages/shared", 0755)
+	os.MkdirAll(root+"/pages/common", 0755)
+	os.WriteFile(root+"/pages/footer.html", []byte("[root footer]"), 0755)
+	os.WriteFile(root+"/pages/shared/footer.html", []byte("[shared footer]"), 0755)
+	os.WriteFile(root+"/pages/blog/footer.html", []byte("[blog footer]"), 0755)
+	os.WriteFile(root+"/pages/common/nav.html", []byte("[nav {@./links}]"), 0755)
+	os.WriteFile(root+"/pages/common/links.html", []byte("[links]"), 0755)
+	os.WriteFile(root+"/pages/blog/post/body.html", []byte("{@footer}{@/shared/footer}{@/footer}{@../../common/nav}\n{@../../../config}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true}, errs: []error{}}
+
+	buf := []byte(`{@body}`)
+	comp.compPage(&buf, []string{"blog", "post"})
+
+	if string(buf) != "[blog footer][shared footer][root footer][nav [links]]" {
+		t.Errorf("unexpected output %q", buf)
+	}
+
+	if len(comp.errs) != 1 || comp.errs[0].Error() != "pages/blog/post/body.html:2: include outside of pages {@../../../config}" {
+		t.Errorf("expected an include outside of pages error, got %v", comp.errs)
+	}
+}
+
+func TestIncludeArgs(t *testing.T) {
+	DebugCompiler = true
+	defer func() { DebugCompiler = false }()
+
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages", 0755)
+	os.WriteFile(root+"/pages/card.html", []byte("<div title=\"{title}\">{?featured}*{/featured}{title} {size} {@inner}</div>\n{@missing x=1}"), 0755)
+	os.WriteFile(root+"/pages/inner.html", []byte("[{title}]"), 0755)
+	os.WriteFile(root+"/pages/loop.html", []byte("{@loop title=\"again\"}"), 0755)
+	os.WriteFile(root+"/pages/link.html", []byte("<a href=\"{href}\">x</a>"), 0755)
+	os.WriteFile(root+"/pages/body.html", []byte("{@card title=\"A \\\"q\\\" <b>\" size=lg featured}|{@card title='B'}|{title}{@link href=javascript:alert(1)}\n{@loop title=x}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true}, errs: []error{}}
+
+	buf := []byte(`{@body}`)
+	comp.compPage(&buf, []string{})
+
+	// args are escaped, and only used by the included file (not by its own includes or the page).
+	// unknown vars and blocks are kept for the page vars
+	expected := `<div title='A "q" <b>'>*A "q" &lt;b> lg [{title}]</div>` +
+		`|<div title="B">{?featured}*{/featured}B {size} [{title}]</div>|{title}<a href="#blocked">x</a>`
+	if string(buf) != expected {
+		t.Errorf("expected %q, got %q", expected, buf)
+	}
+
+	expectedErrs := []string{
+		"pages/card.html:2: include not found {@missing x=1}",
+		"pages/loop.html:1: include cycle {@loop title=\"again\"}: pages/loop.html -> pages/loop.html",
+	}
+
+	if len(comp.errs) != len(expectedErrs) {
+		t.Fatalf("expected %d errors, got %v", len(expectedErrs), comp.errs)
+	}
+
+	for i, err := range comp.errs {
+		if err.Error() != expectedErrs[i] {
+			t.Errorf("expected %q, got %q", expectedErrs[i], err)
+		}
+	}
+}
+
+func TestRemoveDynamicPage(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/account", 0755)
+	os.WriteFile(root+"/pages/account/@profile.html", []byte("<p>{name}</p>"), 0755)
+
+	comp := &compiler{config: &Config{Root: root}, dynPages: map[string][]*tempNode{}}
+	comp.compPages()
+
+	path := root + "/dist/account/@profile.html"
+	if _, err := comp.dynamicPage(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// a removed @page is not rendered from the cache
+	os.Remove(root + "/pages/account/@profile.html")
+	comp.removeDynamicPage("account/@profile.html")
+
+	if _, err := comp.dynamicPage(path); err == nil {
+		t.Error("expected the removed page to be removed from the cache")
+	}
+}
+
+func TestCollections(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/blog/old-post", 0755)
+	os.MkdirAll(root+"/pages/blog/new-post", 0755)
+	os.MkdirAll(root+"/pages/blog/assets", 0755)
+	os.WriteFile(root+"/pages/blog/old-post/body.md", []byte("---\ndate: 2024-05-01\nsummary: Old\n---\n# Old"), 0755)
+	os.WriteFile(root+"/pages/blog/new-post/body.md", []byte("---\ntitle: New Post\ndate: 2025-05-01\ntags: [go, web]\n---\n# New"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, Collections: []string{"/blog/"}}}
+	comp.loadCollections()
+
+	lookup := lookupVars(comp.collectionVars([]string{"blog"}))
+
+	tests := []struct {
+		src string
+		out string
+	}{
+		{`{*collection}{.url} {.title} {.summary}|{/collection}`, `/blog/new-post New Post |/blog/old-post Old-post Old|`},
+		{`{*collections.blog | where "tags" "web"}{.slug}{/collections.blog}`, `new-post`},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, false)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+
+	if !comp.inCollection("blog/new-post/body.md") || comp.inCollection("blogs/body.md") {
+		t.Error("expected only pages in the blog directory to be in a collection")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	list := []Data{}
+	for i := 0; i < 5; i++ {
+		list = append(list, Data{"title": strconv.Itoa(i)})
+	}
+
+	comp := &compiler{config: &Config{}, collections: map[string]Data{"": {"collections.blog": list}}}
+
+	if pages := comp.paginate("", []string{"blog"}, Data{"title": "Blog"}); pages != nil {
+		t.Errorf("expected no pages without `paginate`, got %v", pages)
+	}
+
+	pages := comp.paginate("", []string{"blog"}, Data{"title": "Blog", "paginate": "2"})
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+
+	src := parseTemp([]byte(`{title}: {*collection}{.title}{/collection} {pagination.page}/{pagination.pages} <{pagination.prev}|{pagination.next}> {*pagination.numbers}{?.current}[{.num}]{:else}{.num}{/.current}{/pagination.numbers}`))
+
+	expected := []string{
+		`Blog: 01 1/3 <|/blog/page/2> [1]23`,
+		`Blog: 23 2/3 </blog|/blog/page/3> 1[2]3`,
+		`Blog: 4 3/3 </blog/page/2|> 12[3]`,
+	}
+
+	for i, vars := range pages {
+		if out := string(renderTemp(src, lookupVars(vars), false)); out != expected[i] {
+			t.Errorf("page %d: expected %q, got %q", i+1, expected[i], out)
+		}
+	}
+
+	// translations link to the pages of their locale
+	comp.config.Locales = []string{"en", "es"}
+	comp.collections["es"] = comp.collections[""]
+	pages = comp.paginate("es", []string{"blog"}, Data{"title": "Blog", "paginate": "2"})
+
+	if out := string(renderTemp(src, lookupVars(pages[1]), false)); out != `Blog: 23 2/3 </es/blog|/es/blog/page/3> 1[2]3` {
+		t.Errorf("unexpected translated page %q", out)
+	}
+}
+
+func TestTaxonomies(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/blog/intro", 0755)
+	os.MkdirAll(root+"/pages/blog/web-apps", 0755)
+	os.MkdirAll(root+"/pages/tags", 0755)
+	os.WriteFile(root+"/pages/blog/intro/body.md", []byte("---\ndate: 2024-05-01\ntags: [Go]\n---\n# Intro"), 0755)
+	os.WriteFile(root+"/pages/blog/web-apps/body.md", []byte("---\ndate: 2025-05-01\ntags: go, Web Apps\ncategories: [guides]\n---\n# Web"), 0755)
+	os.WriteFile(root+"/pages/tags/[term].html", []byte("{term.name}: {*term.pages}{.url} {/term.pages}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true, Taxonomies: []string{"tags", "categories"}}}
+	if !comp.loadTaxonomies() {
+		t.Fatal("expected the taxonomies to change")
+	}
+
+	lookup := lookupVars(comp.taxonomyVars([]string{"tags"}))
+
+	tests := []struct {
+		src string
+		out string
+	}{
+		{`{*taxonomy}{.name} {.url} ({.count})|{/taxonomy}`, `Go /tags/go (2)|Web Apps /tags/web-apps (1)|`},
+		{`{*taxonomies.categories}{.slug}: {*.pages}{.title}{/.pages}{/taxonomies.categories}`, `guides: Web-apps`},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, false)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+
+	if comp.loadTaxonomies() {
+		t.Error("expected the taxonomies to be unchanged")
+	}
+
+	comp.compParamPages(root+"/pages/tags", root+"/dist/tags", []string{"tags"})
+
+	if b, err := os.ReadFile(root + "/dist/tags/go.html"); err != nil || !bytes.Contains(b, []byte("Go: /blog/web-apps /blog/intro")) {
+		t.Errorf("unexpected term page %q (%v)", b, err)
+	}
+
+	// term pages are removed when the term is no longer used
+	os.WriteFile(root+"/pages/blog/web-apps/body.md", []byte("---\ntags: go\n---\n# Web"), 0755)
+	if !comp.loadTaxonomies() {
+		t.Fatal("expected the taxonomies to change")
+	}
+	comp.compParamPages(root+"/pages/tags", root+"/dist/tags", []string{"tags"})
+
+	if _, err := os.Stat(root + "/dist/tags/web-apps.html"); err == nil {
+		t.Error("expected the web-apps term page to be removed")
+	}
+}
+
+func TestDataFiles(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/data/pricing", 0755)
+	os.WriteFile(root+"/data/team.yml", []byte("- name: Ann\n  role: CEO\n- name: Bob\n  role: CTO\n"), 0755)
+	os.WriteFile(root+"/data/site.json", []byte(`{"name": "Acme", "founded": 1999}`), 0755)
+	os.WriteFile(root+"/data/pricing/plans.csv", []byte("name,price\nBasic,5\nPro,20\n"), 0755)
+	os.WriteFile(root+"/data/pricing/limits.toml", []byte("[pro]\nusers = 10\n"), 0755)
+	os.WriteFile(root+"/data/notes.txt", []byte("ignored"), 0755)
+
+	comp := &compiler{config: &Config{Root: root}}
+	comp.loadData()
+
+	lookup := lookupVars(dataVars{comp: comp, page: "about"})
+
+	tests := []struct {
+		src string
+		out string
+	}{
+		{`{*data.team}{.name} ({.role}) {/data.team}`, `Ann (CEO) Bob (CTO) `},
+		{`{data.site.name} {data.site.founded}`, `Acme 1999`},
+		{`{*data.pricing.plans | sort "price" desc}{.name}: {.price} {/data.pricing.plans}`, `Pro: 20 Basic: 5 `},
+		{`{data.pricing.limits.pro.users}{?data.notes}notes{/data.notes}`, `10`},
+	}
+
+	for _, test := range tests {
+		if out := string(renderTemp(parseTemp([]byte(test.src)), lookup, false)); out != test.out {
+			t.Errorf("%s: expected %q, got %q", test.src, test.out, out)
+		}
+	}
+
+	for _, key := range []string{"team", "site", "pricing", "notes"} {
+		if !comp.dataDeps[key]["about"] {
+			t.Errorf("expected the about page to depend on data.%s", key)
+		}
+	}
+}
+
+func TestTOML(t *testing.T) {
+	res, err := parseTOML([]byte(`# pricing
+title = "Plans" # inline comment
+"quoted key" = 'C:\path'
+updated = 2025-05-01 09:30:00Z
+size.max = 1_000
+ratio = 0.5
+tags = [
+  "a",
+  "b", # trailing comma
+]
+
+[site]
+enabled = true
+owner = { name = "Ann", roles = ["admin"] }
+desc = """
+Line one \
+  continued"""
+
+[[plans]]
+name = "Basic"
+price = 0x10
+
+[[plans]]
+name = "Pro"
+[plans.limits]
+users = -5
+
+[dates]
+local = 2025-05-01T09:30:00
+day = 2025-05-01
+time = 07:32:00.5
+raw = '''
+C:\path
+  'kept''''
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]any{
+		"title":      "Plans",
+		"quoted key": `C:\path`,
+		"updated":    "2025-05-01T09:30:00Z",
+		"size":       map[string]any{"max": int64(1000)},
+		"ratio":      0.5,
+		"tags":       []any{"a", "b"},
+		"site": map[string]any{
+			"enabled": true,
+			"owner":   map[string]any{"name": "Ann", "roles": []any{"admin"}},
+			"desc":    "Line one continued",
+		},
+		"plans": []any{
+			map[string]any{"name": "Basic", "price": int64(16)},
+			map[string]any{"name": "Pro", "limits": map[string]any{"users": int64(-5)}},
+		},
+		"dates": map[string]any{
+			"local": "2025-05-01T09:30:00",
+			"day":   "2025-05-01",
+			"time":  "07:32:00.5",
+			"raw":   "C:\\path\n  'kept'",
+		},
+	}
+
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %#v, got %#v", expected, res)
+	}
+
+	for src, msg := range map[string]string{
+		"a = \"open\n":           `line 1: strings cannot contain newlines`,
+		"a = 1\nb = nope":        `line 2: expected value but found "nope" instead`,
+		"a = 1\n[a.b]\n":         `line 2: Key 'a' was already created as a hash.`,
+		"a = [1, 2\nb = 3":       `line 2: expected a comma (',') or array terminator (']'), but got 'b'`,
+		"a = 1\na = 2":           `line 2: Key 'a' has already been defined.`,
+		"[t]\nx = 1\n[t]\ny = 2": `line 3: Key 't' has already been defined.`,
+	} {
+		if _, err := parseTOML([]byte(src)); err == nil || err.Error() != msg {
+			t.Errorf("%q: expected error %q, got %v", src, msg, err)
+		}
+	}
+}
+
+func TestParamPages(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/products", 0755)
+	os.MkdirAll(root+"/pages/team", 0755)
+	os.MkdirAll(root+"/data", 0755)
+	os.WriteFile(root+"/data/products.yml", []byte("- slug: blue-widget\n  name: Blue Widget\n  price: 5\n- slug: Red Widget\n  name: Red Widget\n  price: 10\n- slug: v1.2\n  name: Version\n  price: 1\n- name: No Slug\n"), 0755)
+	os.WriteFile(root+"/pages/products/[slug].md", []byte("---\ntitle: Product\n---\n{name}: ${price} ({uri})"), 0755)
+	os.WriteFile(root+"/pages/team/[id].html", []byte("---\nsource: people\n---\n{id}: {name}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true}}
+	comp.loadData()
+	comp.compParamPages(root+"/pages/products", root+"/dist/products", []string{"products"})
+	comp.compParamPages(root+"/pages/team", root+"/dist/team", []string{"team"})
+
+	for path, expected := range map[string]string{
+		"blue-widget":  "Blue Widget: $5 (products/blue-widget)",
+		"Red%20Widget": "Red Widget: $10 (products/Red%20Widget)",
+		"v1.2":         "Version: $1 (products/v1.2)",
+	} {
+		if b, err := os.ReadFile(root + "/dist/products/" + path + ".html"); err != nil || !bytes.Contains(b, []byte(expected)) {
+			t.Errorf("%s: expected %q, got %q (%v)", path, expected, b, err)
+		}
+	}
+
+	// a Go data source replaces the page data when registered
+	app := &App{compiler: comp}
+	app.DataSource("people", func() ([]Data, error) {
+		return []Data{{"id": 1, "name": "Ann"}, {"id": 2, "name": "Bob"}}, nil
+	})
+
+	if b, err := os.ReadFile(root + "/dist/team/2.html"); err != nil || !bytes.Contains(b, []byte("2: Bob")) {
+		t.Errorf("unexpected page %q (%v)", b, err)
+	}
+
+	app.DataSource("people", func() ([]Data, error) {
+		return []Data{{"id": 1, "name": "Ann"}}, nil
+	})
+
+	if _, err := os.Stat(root + "/dist/team/2.html"); err == nil {
+		t.Error("expected the page of a removed record to be removed")
+	}
+
+	// a draft or scheduled template does not generate pages
+	comp.config.DebugMode = false
+	os.WriteFile(root+"/pages/products/[slug].md", []byte("---\ndraft: yes\n---\n{name}"), 0755)
+	comp.compParamPages(root+"/pages/products", root+"/dist/products", []string{"products"})
+
+	if _, err := os.Stat(root + "/dist/products/blue-widget.html"); err == nil {
+		t.Error("expected the pages of a draft template to be removed")
+	}
+
+	soon := time.Now().Add(time.Hour).Format("2006-01-02T15:04:05")
+	os.WriteFile(root+"/pages/products/[slug].md", []byte("---\npublish: "+soon+"\n---\n{name}"), 0755)
+	comp.compParamPages(root+"/pages/products", root+"/dist/products", []string{"products"})
+
+	if _, err := os.Stat(root + "/dist/products/blue-widget.html"); err == nil {
+		t.Error("expected a scheduled template to wait until it is published")
+	}
+	if _, ok := comp.schedule["products/*"]; !ok {
+		t.Error("expected the scheduled template to be added to the schedule")
+	}
+}
+
+func TestPublishSchedule(t *testing.T) {
+	root := t.TempDir()
+	soon := time.Now().Add(time.Second).Format("2006-01-02T15:04:05")
+
+	for name, head := range map[string]string{
+		"live":    "date: 2024-01-01",
+		"draft":   "draft: yes",
+		"expired": "expires: 2020-01-01",
+		"later":   "publish: " + soon,
+	} {
+		os.MkdirAll(root+"/pages/blog/"+name, 0755)
+		os.WriteFile(root+"/pages/blog/"+name+"/body.md", []byte("---\n"+head+"\n---\n# "+name), 0755)
+	}
+	os.WriteFile(root+"/pages/blog/body.html", []byte("{*collection}{.slug} {/collection}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, DebugMode: true, Collections: []string{"blog"}}}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(root + "/dist/blog/" + name + ".html")
+		_, errGz := os.Stat(root + "/dist/blog/" + name + ".html.gz")
+		return err == nil || errGz == nil
+	}
+
+	comp.loadCollections()
+	comp.compPages()
+
+	for name, expected := range map[string]bool{"live": true, "draft": true, "expired": false, "later": false} {
+		if exists(name) != expected {
+			t.Errorf("%s: expected the page to exist: %v", name, expected)
+		}
+	}
+
+	// drafts are only compiled in debug mode
+	comp.config.DebugMode = false
+	comp.loadCollections()
+	comp.compPages()
+
+	if exists("draft") {
+		t.Error("expected the draft to be removed")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	comp.compScheduledPages()
+
+	if !exists("later") {
+		t.Error("expected the scheduled page to be published")
+	}
+
+	if b, err := Gunzip(root + "/dist/blog.html.gz"); err != nil || !bytes.Contains(b, []byte("live later")) {
+		t.Errorf("expected the collection to list the published page, got %q (%v)", b, err)
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/dist/blog", 0755)
+	for _, page := range []string{"index", "about", "blog/#post", "blog/hidden", "blog/noindex", "@widget"} {
+		os.WriteFile(root+"/dist/"+page+".html", []byte("<html></html>"), 0755)
+	}
+
+	limit := sitemapLimit
+	sitemapLimit = 2
+	defer func() { sitemapLimit = limit }()
+
+	comp := &compiler{config: &Config{Root: root, BaseURL: "https://example.com/"}}
+	comp.addSitemapPage("/", root+"/dist/index", Data{"priority": "0.8", "changefreq": "Weekly", "lastmod": "2025-01-02"})
+	comp.addSitemapPage("/about", root+"/dist/about", Data{})
+	comp.addSitemapPage("/blog/post", root+"/dist/blog/post", Data{"lastmod": "2025-03-04"})
+	comp.addSitemapPage("/blog/hidden", root+"/dist/blog/hidden", Data{"sitemap": "no"})
+	comp.addSitemapPage("/blog/noindex", root+"/dist/blog/noindex", Data{"noindex": "yes"})
+	comp.addSitemapPage("/removed", root+"/dist/removed", Data{})
+	comp.writeSitemap()
+
+	lastmod := func(date string) string {
+		t, _ := varTime(date)
+		return t.Format(time.RFC3339)
+	}
+
+	for file, expected := range map[string][]string{
+		"sitemap.xml":   {"<sitemapindex", "<loc>https://example.com/sitemap-1.xml</loc><lastmod>" + lastmod("2025-01-02") + "</lastmod>", "<loc>https://example.com/sitemap-2.xml</loc><lastmod>" + lastmod("2025-03-04") + "</lastmod>"},
+		"sitemap-1.xml": {"<loc>https://example.com/</loc><lastmod>" + lastmod("2025-01-02") + "</lastmod><changefreq>weekly</changefreq><priority>0.8</priority>", "<loc>https://example.com/about</loc>"},
+		"sitemap-2.xml": {"<loc>https://example.com/blog/post</loc>"},
+		"robots.txt":    {"User-agent: *\nDisallow:\n\nSitemap: https://example.com/sitemap.xml\n"},
+	} {
+		b, err := os.ReadFile(root + "/dist/" + file)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		for _, text := range expected {
+			if !bytes.Contains(b, []byte(text)) {
+				t.Errorf("%s: expected %q in %q", file, text, b)
+			}
+		}
+
+		if bytes.Contains(b, []byte("hidden")) || bytes.Contains(b, []byte("noindex")) || bytes.Contains(b, []byte("removed")) || bytes.Contains(b, []byte("widget")) {
+			t.Errorf("%s: unexpected page in %q", file, b)
+		}
+	}
+
+	if _, err := os.Stat(root + "/dist/sitemap-3.xml"); err == nil {
+		t.Error("expected 2 sitemaps")
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/changelog/v1", 0755)
+	os.MkdirAll(root+"/pages/changelog/v2", 0755)
+	os.WriteFile(root+"/pages/changelog/v1/body.md", []byte("---\ntitle: Version 1\ndate: 2024-01-01\n---\n# V1 & more\n\n[docs](/docs) ![logo](/img/logo.png) [cdn](//cdn.example.com/x)"), 0755)
+	os.WriteFile(root+"/pages/changelog/v2/body.md", []byte("---\ntitle: Version 2\ndate: 2025-01-01\nsummary: The second release\nauthor: Ann\n---\nNew {app}"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, Title: "Site", AppTitle: "App", BaseURL: "https://example.com", Feeds: []string{"changelog"}}}
+	comp.compFeeds([]string{"changelog"}, Data{})
+
+	var feed struct {
+		Title   string `json:"title"`
+		FeedURL string `json:"feed_url"`
+		Items   []struct {
+			URL     string `json:"url"`
+			Title   string `json:"title"`
+			Summary string `json:"summary"`
+			Content string `json:"content_html"`
+			Date    string `json:"date_published"`
+			Authors []struct {
+				Name string `json:"name"`
+			} `json:"authors"`
+		} `json:"items"`
+	}
+
+	b, err := os.ReadFile(root + "/dist/changelog/feed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Title != "Changelog | Site" || feed.FeedURL != "https://example.com/changelog/feed.json" || len(feed.Items) != 2 {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+
+	if item := feed.Items[0]; item.URL != "https://example.com/changelog/v2" || item.Summary != "The second release" || item.Content != "<p>New App</p>" || len(item.Authors) != 1 || item.Authors[0].Name != "Ann" {
+		t.Errorf("unexpected item %+v", item)
+	}
+
+	if item := feed.Items[1]; item.Title != "Version 1" || !strings.Contains(item.Content, "V1 & more") {
+		t.Errorf("unexpected item %+v", item)
+	}
+
+	// root relative urls use the base url, so feed readers can resolve them
+	for _, expected := range []string{`href="https://example.com/docs"`, `src="https://example.com/img/logo.png"`, `href="//cdn.example.com/x"`} {
+		if !strings.Contains(feed.Items[1].Content, expected) {
+			t.Errorf("expected %q in %q", expected, feed.Items[1].Content)
+		}
+	}
+
+	for _, test := range []struct{ file, expected string }{
+		{"feed.xml", `<title>Version 1</title><link href="https://example.com/changelog/v1"/>`},
+		{"feed.xml", `<updated>2025-01-01T00:00:00Z</updated>` + "\n" + `<author><name>Site</name></author>`},
+		{"rss.xml", `<content:encoded>&lt;p&gt;New App&lt;/p&gt;</content:encoded>`},
+	} {
+		if b, err := os.ReadFile(root + "/dist/changelog/" + test.file); err != nil || !bytes.Contains(b, []byte(test.expected)) {
+			t.Errorf("%s: expected %q in %q (%v)", test.file, test.expected, b, err)
+		}
+	}
+
+	// feeds can be turned off in the front matter of the directory
+	comp.compFeeds([]string{"changelog"}, Data{"feed": "no"})
+	if _, err := os.Stat(root + "/dist/changelog/feed.xml"); err == nil {
+		t.Error("expected the feed to be removed")
+	}
+}
+
+func TestTOC(t *testing.T) {
+	comp := &compiler{config: &Config{Title: "Site"}}
+
+	buf := []byte(`<nav><h2>Menu</h2></nav><h2 id="h-intro">Intro</h2><h3>Set {name} &amp; Go</h3><h4>Deep</h4><h2>Intro</h2>` +
+		`{*toc}[{.title}|{.url}|{*.children}{.title}{/.children}]{/toc}{#toc}`)
+	comp.compVars(&buf, []string{"docs"}, false, Data{"name": "Up", "tocdepth": "2"})
+
+	expected := `<h2 id="h-intro">Intro</h2><h3 id="h-set-up-go">Set Up &amp; Go</h3><h4>Deep</h4><h2 id="h-intro-1">Intro</h2>` +
+		`[Intro|#h-intro|Set Up &amp; Go][Intro|#h-intro-1|]` +
+		`<ul><li><a href="#h-intro">Intro</a><ul><li><a href="#h-set-up-go">Set Up &amp; Go</a></li></ul></li><li><a href="#h-intro-1">Intro</a></li></ul>`
+	if !strings.HasSuffix(string(buf), expected) {
+		t.Errorf("expected %q, got %q", expected, buf)
+	}
+
+	// the toc can be turned off in the front matter
+	buf = []byte(`<h2>Intro</h2>{?toc}toc{:else}none{/toc}`)
+	comp.compVars(&buf, []string{"docs"}, false, Data{"toc": "no"})
+	if string(buf) != `<h2>Intro</h2>none` {
+		t.Errorf("unexpected %q", buf)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	buf := []byte("```go {2} linenos=3\nx := \"a\" // b\nreturn nil\n```")
+	(&compiler{}).compileMD(&buf, "")
+
+	expected := `<pre class="code linenos" data-lang="go"><button type="button" class="code-copy" aria-label="Copy code">Copy</button><code class="language-go">` +
+		`<span class="line" data-line="3">x := <span class="hl-string">&#34;a&#34;</span> <span class="hl-comment">// b</span>` + "\n" +
+		`</span><span class="line hl-line" data-line="4"><span class="hl-keyword">return</span> <span class="hl-literal">nil</span>` + "\n" +
+		`</span></code></pre>`
+	if strings.TrimSpace(string(buf)) != expected {
+		t.Errorf("expected %q, got %q", expected, buf)
+	}
+
+	buf = []byte("```html nocopy\n<a href=\"/\">x</a>\n```")
+	(&compiler{}).compileMD(&buf, "")
+	if !bytes.Contains(buf, []byte(`&lt;<span class="hl-tag">a</span> <span class="hl-attr">href</span>=<span class="hl-string">&#34;/&#34;</span>`)) || bytes.Contains(buf, []byte("code-copy")) {
+		t.Errorf("unexpected %q", buf)
+	}
+
+	// raw strings, nested template strings, and heredocs are not split into comments
+	for _, test := range []struct {
+		lang, code, expected string
+	}{
+		{"go", "x := `a // b`", "`a // b`"},
+		{"js", "let s = `a ${`b ${c}`} // d`;", "}`} // d`"},
+		{"bash", "cat <<EOF\n# not a comment\nEOF", "&lt;&lt;EOF\n# not a comment\nEOF"},
+	} {
+		b := []byte{}
+		for _, token := range highlightCode(test.lang, test.code) {
+			if token.class == "hl-string" {
+				b = append(b, html.EscapeString(token.text)...)
+			} else if token.class == "hl-comment" {
+				t.Errorf("%s: unexpected comment %q", test.lang, token.text)
+			}
+		}
+
+		if !bytes.Contains(b, []byte(test.expected)) {
+			t.Errorf("%s: expected the string %q in %q", test.lang, test.expected, b)
+		}
+	}
+}
+
+func TestMarkdownConfig(t *testing.T) {
+	buf := []byte("> [!WARNING] Be careful\n> Do **not** this.\n>\n> [!TIP]\n> Try [this](https://example.com).\n\n# Title")
+	(&compiler{}).compileMD(&buf, "")
+
+	for _, expected := range []string{
+		`<div class="callout callout-warning"><p class="callout-title">Be careful</p>` + "\n<p>Do <strong>not</strong> this.</p>\n</div>",
+		`<div class="callout callout-tip"><p class="callout-title">Tip</p>` + "\n" + `<p>Try <a href="https://example.com" target="_blank">this</a>.</p>` + "\n</div>",
+		`<h1 id="h-title">Title</h1>`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %q in %q", expected, buf)
+		}
+	}
+
+	no, prefix := false, ""
+	comp := &compiler{config: &Config{Markdown: MarkdownConfig{Callouts: &no, HeadingOffset: 1, HeadingPrefix: &prefix, ExternalLinks: "self", Nofollow: true, Footnotes: true}}}
+
+	buf = []byte("> [!NOTE]\n> x\n\n# Title\n\n[link](https://example.com)[^1]\n\n[^1]: note")
+	comp.compileMD(&buf, "")
+
+	for _, expected := range []string{
+		"<blockquote>\n<p>[!NOTE]\nx</p>\n</blockquote>",
+		`<h2 id="title">Title</h2>`,
+		`<a href="https://example.com" rel="nofollow">link</a>`,
+		`<div class="footnotes">`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %q in %q", expected, buf)
+		}
+	}
+
+	// the markdown filter and the toc use the config of the app
+	prefix = "sec-"
+	buf = []byte(`{text | markdown}<h2>Other</h2>{#toc}`)
+	comp.compVars(&buf, []string{}, false, Data{"text": "# Title\n\n[link](https://example.com)"})
+	for _, expected := range []string{
+		`<h2 id="sec-title">Title</h2>`,
+		`<a href="https://example.com" rel="nofollow">link</a>`,
+		`<h2 id="sec-other">Other</h2>`,
+		`<a href="#sec-other">Other</a>`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %q in %q", expected, buf)
+		}
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	buf := []byte("---\nauthorName: Ann\napp_title: Docs\ncount: 3\nprice: 9.5\npublished: true\ndate: 2024-01-02\nauthor:\n  name: Ann\n  Links: [a, b]\n---\nbody")
+	config := Data{}
+	parseFrontMatter(&buf, config)
+
+	if string(buf) != "body" {
+		t.Errorf("expected front matter to be removed, got %q", buf)
+	}
+
+	for key, expected := range map[string]any{
+		"authorName": "Ann",
+		"authorname": "Ann",
+		"app_title":  "Docs",
+		"apptitle":   "Docs",
+		"count":      3,
+		"price":      9.5,
+		"published":  true,
+		"date":       "2024-01-02",
+	} {
+		if config[key] != expected {
+			t.Errorf("%s: expected %#v, got %#v", key, expected, config[key])
+		}
+	}
+
+	b := []byte(`{page.author.name} {page.author.Links.1} {author.Links | join} {?published}yes{/published} {count | number 1}`)
+	(&compiler{config: &Config{}}).compVars(&b, []string{"docs"}, false, config)
+	if string(b) != "Ann b a, b yes 3.0" {
+		t.Errorf("unexpected %q", b)
+	}
+
+	// nested keys are matched exactly
+	b = []byte(`[{author.links}] [{author.Name}]`)
+	(&compiler{config: &Config{}}).compVars(&b, []string{"docs"}, false, config)
+	if string(b) != "[] []" {
+		t.Errorf("unexpected %q", b)
+	}
+
+	// nested maps keep only their own keys
+	b = []byte(`{author | json} {author | length}`)
+	(&compiler{config: &Config{}}).compVars(&b, []string{"docs"}, false, config)
+	if string(b) != `{&#34;Links&#34;:[&#34;a&#34;,&#34;b&#34;],&#34;name&#34;:&#34;Ann&#34;} 2` {
+		t.Errorf("unexpected %q", b)
+	}
+
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/docs", 0755)
+	os.WriteFile(root+"/pages/docs/body.md", []byte("---\ntitle: Docs\ntags: [go]\n---\n# Docs"), 0755)
+
+	app := App{Config: Config{Root: root}, compiler: &compiler{config: &Config{Root: root}}}
+	if meta, ok := app.PageMeta("/docs"); !ok || meta["title"] != "Docs" || meta["url"] != "/docs" || len(meta["tags"].([]any)) != 1 {
+		t.Errorf("unexpected page meta %v", meta)
+	}
+	if _, ok := app.PageMeta("/missing"); ok {
+		t.Error("expected a missing page to not be found")
+	}
+}
+
+func TestSafeMarkdown(t *testing.T) {
+	buf := []byte("# Hi {name}\n\n<script>alert(1)</script><b onclick=\"x()\">bold</b> [link](javascript:alert(1)) [ok](https://example.com)\n\n<a href=\" JaVa&#83;cript:x\">y</a>{@include secret}\n\n> [!NOTE]\n> ```go\n> return nil\n> ```")
+	(&compiler{}).compileMarkdown(&buf, SafeMode)
+
+	for _, expected := range []string{
+		`<h1 id="user-content-h-hi-name">Hi {name}</h1>`,
+		`<b>bold</b>`,
+		`<a target="_blank" rel="nofollow noopener noreferrer">link</a>`,
+		`<a href="https://example.com" target="_blank" rel="nofollow noopener noreferrer">ok</a>`,
+		`<a>y</a>{@include secret}`,
+		`<div class="callout callout-note">`,
+		`<span class="hl-keyword">return</span>`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %q in %q", expected, buf)
+		}
+	}
+
+	if bytes.Contains(buf, []byte("alert")) || bytes.Contains(buf, []byte("onclick")) {
+		t.Errorf("unexpected %q", buf)
+	}
+
+	// the markdown filter is safe by default at request time
+	var out bytes.Buffer
+	comp := &compiler{config: &Config{}}
+	comp.renderDynamicPage(&out, parseTemp([]byte(`<div>{x | markdown}</div><div>{x | markdown trusted}</div>`)), Data{"x": "<script>alert(1)</script>**hi**"})
+
+	if expected := "<div><p><strong>hi</strong></p>\n</div><div><p><script>alert(1)</script><strong>hi</strong></p>"; !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestMath(t *testing.T) {
+	buf := []byte("Area: $\\pi r^2$\n\n$$\n\\sum_{i=1}^n \\frac{1}{i}\n$$\n\nBad: $\\frac{1}{2$")
+	errs := (&compiler{}).compileMarkdown(&buf, TrustedMode)
+
+	for _, expected := range []string{
+		`<math><semantics><mrow><mi>π</mi><msup><mi>r</mi><mn>2</mn></msup></mrow><annotation encoding="application/x-tex">\pi r^2</annotation></semantics></math>`,
+		`<math display="block"><semantics><mrow><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><mfrac><mrow><mn>1</mn></mrow><mrow><mi>i</mi></mrow></mfrac></mrow>`,
+		`<math><merror><mtext>$\frac&#123;1&#125;&#123;2$</mtext></merror></math>`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %q in %q", expected, buf)
+		}
+	}
+
+	if len(errs) != 1 || errs[0].Error() != `: invalid math $\frac{1}{2$: missing }` {
+		t.Errorf("expected an invalid math error, got %v", errs)
+	}
+
+	// untrusted markdown can not place math in attributes with its placeholder
+	buf = []byte("$x$ [a](http://x \"webxmath0x\") ![i](webxmath0x) webxmath0x")
+	(&compiler{}).compileMarkdown(&buf, SafeMode)
+	if bytes.Count(buf, []byte("<math>")) != 1 || !strings.Contains(string(buf), `title="webxmath0x"`) || !strings.Contains(string(buf), `src="webxmath0x"`) {
+		t.Errorf("unexpected math in %q", buf)
+	}
+
+	for tex, expected := range map[string]string{
+		`\left( x`:                          `missing \right`,
+		`x^2^3`:                             `double superscript`,
+		`\foo`:                              `unknown command \foo`,
+		`{a`:                                `missing }`,
+		`a}`:                                `unexpected }`,
+		`\frac{1}`:                          `missing argument for \frac`,
+		`\sqrt[3{x}`:                        `missing ] after \sqrt`,
+		`\left( x \right`:                   `missing delimiter after \right`,
+		`\begin{matrix} a \end{bmatrix}`:    `\begin{matrix} ended by \end{bmatrix}`,
+		`\begin{pmatrix} 1 & 2 \\ 3 & 4 \\`: `missing \end{pmatrix}`,
+	} {
+		if _, err := latexMathML(tex, false); err == nil || err.Error() != expected {
+			t.Errorf("expected %q for %q, got %v", expected, tex, err)
+		}
+	}
+}
+
+func TestMathErrors(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/docs", 0755)
+	os.WriteFile(root+"/pages/docs/body.md", []byte("# Math\n\nHalf: $\\frac{1}{2$\n\nExtra: $a}$\n\n$$\n\\foo{x}\n$$\n\nOk: $x^2$"), 0755)
+
+	// strict mode collects the errors, and still compiles the page
+	comp := &compiler{config: &Config{Root: root, Strict: true}, errs: []error{}}
+	comp.compPages()
+
+	expected := []string{
+		`pages/docs/body.md:3: invalid math $\frac{1}{2$: missing }`,
+		`pages/docs/body.md:5: invalid math $a}$: unexpected }`,
+		`pages/docs/body.md:8: invalid math $$\foo{x}$$: unknown command \foo`,
+	}
+
+	if len(comp.errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), comp.errs)
+	}
+
+	for i, err := range comp.errs {
+		var mathErr *MathError
+		if !errors.As(err, &mathErr) || err.Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], err)
+		}
+	}
+
+	if b, err := Gunzip(root + "/dist/docs.html.gz"); err != nil || bytes.Count(b, []byte("<merror>")) != 3 || !bytes.Contains(b, []byte("<msup><mi>x</mi><mn>2</mn></msup>")) {
+		t.Errorf("unexpected page %q (%v)", b, err)
+	}
+}
+
+func TestLocales(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/es/about", 0755)
+	os.MkdirAll(root+"/pages/about", 0755)
+	os.MkdirAll(root+"/pages/contact", 0755)
+	os.MkdirAll(root+"/dist/es", 0755)
+	os.WriteFile(root+"/pages/body.html", []byte("<p>home</p>"), 0755)
+	os.WriteFile(root+"/pages/body.es.html", []byte("<p>inicio</p>"), 0755)
+	os.WriteFile(root+"/pages/about/body.html", []byte("<p>about</p>"), 0755)
+	os.WriteFile(root+"/pages/es/about/body.html", []byte("<p>acerca</p>"), 0755)
+	os.WriteFile(root+"/pages/contact/body.html", []byte("<p>contact</p>"), 0755)
+	os.WriteFile(root+"/dist/es/about.html", []byte("<html></html>"), 0755)
+
+	comp := &compiler{config: &Config{Root: root, Locales: []string{"en", "es"}}, errs: []error{}}
+
+	for path, expected := range map[string]string{
+		"":           "<p>home</p>",
+		"es":         "<p>inicio</p>",
+		"about":      "<p>about</p>",
+		"es/about":   "<p>acerca</p>",
+		"es/contact": "<p>contact</p>",
+	} {
+		uriPath := []string{}
+		if path != "" {
+			uriPath = strings.Split(path, "/")
+		}
+
+		buf := []byte(`{@body}`)
+		comp.compPage(&buf, uriPath)
+		if string(buf) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, buf)
+		}
+	}
+
+	vars := comp.localeVars([]string{"es", "about"})
+	if vars["lang"] != "es" {
+		t.Errorf("expected lang es, got %v", vars["lang"])
+	}
+
+	translations, _ := json.Marshal(vars["translations"])
+	if string(translations) != `[{"current":false,"lang":"en","url":"/about"},{"current":true,"lang":"es","url":"/es/about"}]` {
+		t.Errorf("unexpected translations %s", translations)
+	}
+
+	translations, _ = json.Marshal(comp.localeVars([]string{"contact"})["translations"])
+	if string(translations) != `[{"current":true,"lang":"en","url":"/contact"}]` {
+		t.Errorf("unexpected translations %s", translations)
+	}
+
+	for url, expected := range map[string]string{
+		"/about":      "/about",
+		"/en/about":   "/about",
+		"/es/about":   "/es/about",
+		"/es/contact": "/contact",
+	} {
+		if res := comp.distURL(url); res != expected {
+			t.Errorf("%s: expected %q, got %q", url, expected, res)
+		}
+	}
+}
+
+func TestLocaleCollections(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(root+"/pages/blog/a", 0755)
+	os.MkdirAll(root+"/pages/blog/b", 0755)
+	os.MkdirAll(root+"/pages/es/blog/c", 0755)
+	os.WriteFile(root+"/pages/blog/a/body.md", []byte("---\ntitle: Hello\ndate: 2024-02-01\ntags: [go]\n---\nhello"), 0755)
+	os.WriteFile(root+"/pages/blog/a/body.es.md", []byte("---\ntitle: Hola\ndate: 2024-02-01\ntags: [go]\n---\nhola"), 0755)
+	os.WriteFile(root+"/pages/blog/b/body.md", []byte("---\ntitle: Bye\ndate: 2024-01-01\n---\nbye"), 0755)
+	os.WriteFile(root+"/pages/es/blog/c/body.md", []byte("---\ntitle: Nuevo\ndate: 2024-03-01\n---\nnuevo"), 0755)
+
+	comp := &compiler{config: &Config{
+		Root:        root,
+		BaseURL:     "https://example.com",
+		Locales:     []string{"en", "es"},
+		Collections: []string{"blog"},
+		Taxonomies:  []string{"tags"},
+		Feeds:       []string{"blog"},
+	}}
+	comp.loadCollections()
+	comp.loadTaxonomies()
+
+	list := func(val any) string {
+		res := []string{}
+		for _, page := range val.([]Data) {
+			res = append(res, varString(page["title"])+" "+varString(page["url"]))
+		}
+		return strings.Join(res, ", ")
+	}
+
+	// translations use their own front matter, and fall back to the default locale
+	if res := list(comp.collectionVars([]string{"blog"})["collection"]); res != "Hello /blog/a, Bye /blog/b" {
+		t.Errorf("unexpected collection %q", res)
+	}
+	if res := list(comp.collectionVars([]string{"es", "blog"})["collection"]); res != "Nuevo /es/blog/c, Hola /es/blog/a, Bye /es/blog/b" {
+		t.Errorf("unexpected translated collection %q", res)
+	}
+
+	terms, _ := comp.taxonomyTermList([]string{"es", "tags"})
+	if len(terms) != 1 || terms[0]["url"] != "/es/tags/go" || list(terms[0]["pages"]) != "Hola /es/blog/a" {
+		t.Errorf("unexpected translated terms %v", terms)
+	}
+
+	comp.compFeeds([]string{"es", "blog"}, Data{})
+	if b, err := os.ReadFile(root + "/dist/es/blog/feed.json"); err != nil || !bytes.Contains(b, []byte(`"url": "https://example.com/es/blog/a"`)) || !bytes.Contains(b, []byte(`"title": "Hola"`)) {
+		t.Errorf("unexpected translated feed %q (%v)", b, err)
+	}
+}
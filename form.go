@@ -150,18 +150,16 @@ func (handler *FormHandler) API(uri string, cb func(c FormCtx) error) {
 	})
 }
 
-func (ctx FormCtx) Render(url string, vars ...Map) error {
-	if len(vars) == 0 {
-		vars = append(vars, Map{})
-	}
-
-	vars[0]["session"] = ctx.session
-	vars[0]["token"] = ctx.token
+func (ctx FormCtx) Render(url string, vars ...Vars) error {
+	vars = append([]Vars{Map{
+		"session": ctx.session,
+		"token":   ctx.token,
+	}}, vars...)
 
 	(*ctx.Session)["token"] = ctx.token
 	ctx.formSession.Set(ctx.session, *ctx.Session, nil)
 
-	return ctx.app.Render(ctx.ctx, url, vars[0])
+	return ctx.app.Render(ctx.ctx, url, vars...)
 }
 
 func (ctx FormCtx) JSON(success bool, json ...map[string]any) error {
@@ -3,10 +3,13 @@ module github.com/tkdeng/webx
 go 1.24.5
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/alecthomas/chroma/v2 v2.24.1
 	github.com/drhodes/golorem v0.0.0-20220328165741-da82e5b29246
 	github.com/gofiber/fiber/v3 v3.0.0-beta.5
 	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
 	github.com/tdewolff/minify/v2 v2.23.10
+	github.com/tdewolff/parse/v2 v2.8.1
 	github.com/tkdeng/gobash v0.0.0-20240829205649-8eb77a299f33
 	github.com/tkdeng/gocrypt v1.0.1
 	github.com/tkdeng/goutil v0.9.2
@@ -17,6 +20,7 @@ require (
 
 require (
 	github.com/andybalholm/brotli v1.2.0 // indirect
+	github.com/dlclark/regexp2 v1.12.0 // indirect
 	github.com/fsnotify/fsnotify v1.9.0 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/gofiber/schema v1.6.0 // indirect
@@ -27,7 +31,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/philhofer/fwd v1.2.0 // indirect
 	github.com/shirou/gopsutil v3.21.11+incompatible // indirect
-	github.com/tdewolff/parse/v2 v2.8.1 // indirect
 	github.com/tinylib/msgp v1.3.0 // indirect
 	github.com/tklauser/go-sysconf v0.3.15 // indirect
 	github.com/tklauser/numcpus v0.10.0 // indirect
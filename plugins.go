@@ -60,3 +60,8 @@ func (plugin *Plugin) Asset(name string, buf []byte, path ...string) {
 		}
 	}
 }
+
+// Filter adds a template filter (see NewFilter)
+func (plugin *Plugin) Filter(name string, cb FilterFunc) {
+	NewFilter(name, cb)
+}
@@ -6,12 +6,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/helmet"
 	"github.com/gofiber/fiber/v3/middleware/static"
 	"github.com/tkdeng/goutil"
 	"github.com/tkdeng/regex"
+	"github.com/tkdeng/simplewebserver/cron"
 )
 
 type Config struct {
@@ -29,6 +31,30 @@ type Config struct {
 
 	Vars Map
 
+	// Collections are page directories that list the front matter of their child pages
+	Collections []string
+
+	// BaseURL is the canonical url of the site (i.e. https://example.com), used by the sitemap
+	BaseURL string
+
+	// Feeds are page directories that generate Atom, RSS, and JSON feeds of their child pages
+	Feeds []string
+
+	// Author is the author of feed entries without an author in their front matter (default is the Title)
+	Author string
+
+	// Taxonomies are front matter lists (i.e. tags, categories) that pages are grouped by
+	Taxonomies []string
+
+	// Markdown sets the markdown extensions used to compile .md pages
+	Markdown MarkdownConfig
+
+	// Locales are the languages of a multilingual site (i.e. [en, es])
+	Locales []string
+
+	// DefaultLocale is the locale of pages without a locale prefix (default is the first locale)
+	DefaultLocale string
+
 	PortHTTP uint16
 	PortSSL  uint16
 
@@ -36,6 +62,9 @@ type Config struct {
 
 	Root string
 
+	// Strict fails the compile on missing or recursive includes and layouts, and invalid math
+	Strict bool
+
 	CSP     bool
 	csp     CSP
 	cspText string
@@ -56,6 +85,30 @@ type CSP struct {
 	ReportUri              string
 }
 
+// MarkdownConfig sets the markdown extensions used to compile .md pages
+//
+// options that are not set keep their defaults
+type MarkdownConfig struct {
+	Tables          *bool // tables (default yes)
+	DefinitionLists *bool // definition lists (default yes)
+	Math            *bool // $inline$ and $$block$$ math (default yes)
+	Smartypants     *bool // smart quotes, dashes, and fractions (default yes)
+	Callouts        *bool // > [!NOTE] callout blocks (default yes)
+	Footnotes       bool  // [^1] footnotes
+
+	// HeadingOffset moves headings down by a number of levels (i.e. 1 turns # into <h2>)
+	HeadingOffset int
+
+	// HeadingPrefix is the prefix of heading ids (default "h-")
+	HeadingPrefix *string
+
+	// ExternalLinks sets the target of links to other sites: "blank" (default) or "self"
+	ExternalLinks string
+
+	// Nofollow adds rel="nofollow" to links to other sites
+	Nofollow bool
+}
+
 type App struct {
 	*fiber.App
 	Config Config
@@ -67,6 +120,27 @@ type App struct {
 
 type Map map[string]string
 
+// Data holds structured render vars
+//
+// unlike Map, values can be any go value (structs, nested maps, lists, numbers, bools, time.Time),
+// and nested values can be accessed by their dot path (i.e. {user.profile.name})
+type Data map[string]any
+
+// Vars can be either a Map or Data
+type Vars interface {
+	get(name string) (any, bool)
+}
+
+func (vars Map) get(name string) (any, bool) {
+	val, ok := vars[name]
+	return val, ok
+}
+
+func (vars Data) get(name string) (any, bool) {
+	val, ok := vars[name]
+	return val, ok
+}
+
 var Helmet helmet.Config
 
 // New loads a new server
@@ -91,7 +165,16 @@ func New(root string, config ...fiber.Config) (App, error) {
 	}
 
 	// compile src
-	compiler := compile(&appConfig)
+	compiler, err := compile(&appConfig)
+	if err != nil {
+		return App{}, err
+	}
+
+	// publish and expire scheduled pages
+	cron.New(time.Minute, func() bool {
+		compiler.compScheduledPages()
+		return true
+	})
 
 	if len(config) == 0 {
 		config = append(config, fiber.Config{
@@ -172,6 +255,37 @@ func New(root string, config ...fiber.Config) (App, error) {
 			return c.Next()
 		}
 
+		// generated sitemap and robots.txt
+		if regex.Comp(`^/(?:robots\.txt|sitemap(?:-[0-9]+|)\.xml)$`).Match([]byte(url)) {
+			if _, err := os.Stat(appConfig.Root + "/dist" + url); err != nil {
+				return c.Next()
+			}
+			return c.SendFile(appConfig.Root + "/dist" + url)
+		}
+
+		// generated feeds
+		if m := regex.Comp(`/(feed\.xml|rss\.xml|feed\.json)$`).RE.FindStringSubmatch(url); m != nil {
+			path, err := goutil.JoinPath(appConfig.Root+"/dist", url)
+			if err != nil {
+				return c.Next()
+			}
+
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				return c.Next()
+			}
+
+			switch m[1] {
+			case "feed.xml":
+				c.Set(fiber.HeaderContentType, "application/atom+xml; charset=utf-8")
+			case "rss.xml":
+				c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+			default:
+				c.Set(fiber.HeaderContentType, "application/feed+json; charset=utf-8")
+			}
+			return c.Send(buf)
+		}
+
 		return app.Render(c, url)
 	})
 
@@ -220,7 +334,9 @@ func (app *App) ListenHTTP() error {
 }
 
 // Compile runs the compiler without loading a new server
-func Compile(root string) {
+//
+// in strict mode, an error will be returned for missing or recursive includes and layouts, and invalid math
+func Compile(root string) error {
 	appConfig := Config{
 		Title:    "Web Server",
 		AppTitle: "WebServer",
@@ -234,7 +350,8 @@ func Compile(root string) {
 	loadConfig(root, &appConfig)
 
 	// compile src
-	compile(&appConfig)
+	_, err := compile(&appConfig)
+	return err
 }
 
 func loadConfig(root string, config *Config) {
@@ -250,8 +367,10 @@ func loadConfig(root string, config *Config) {
 
 // Render a page
 //
-// if the page is not found, it will return a 404 error
-func (app *App) Render(c fiber.Ctx, url string, vars ...Map) error {
+// if the page is not found, it will return a 404 error.
+// urls of a locale without the page use the page of the default locale
+func (app *App) Render(c fiber.Ctx, url string, vars ...Vars) error {
+	url = app.compiler.distURL(url)
 	if url == "/" || url == "" {
 		url = "index"
 	}
@@ -261,6 +380,20 @@ func (app *App) Render(c fiber.Ctx, url string, vars ...Map) error {
 	if err != nil {
 		return c.Next()
 	}
+
+	// render @pages from their cached template
+	if strings.HasPrefix(filepath.Base(path), "@") {
+		nodes, err := app.compiler.dynamicPage(path + ".html")
+		if err != nil {
+			return c.Next()
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTML)
+
+		app.compiler.renderDynamicPage(c, nodes, vars...)
+		return nil
+	}
+
 	path += ".html.gz"
 
 	useGzip := true
@@ -303,23 +436,6 @@ func (app *App) Render(c fiber.Ctx, url string, vars ...Map) error {
 		}
 	}
 
-	if url[0] == '@' {
-		buf, err := os.ReadFile(path)
-		if err != nil {
-			return app.Error(c, 500, "Internal Server Error")
-		}
-
-		c.Set(fiber.HeaderContentType, fiber.MIMETextHTML)
-
-		if len(vars) == 0 {
-			vars = append(vars, Map{})
-		}
-
-		app.compiler.compileDynamicPage(&buf, vars[0])
-
-		return c.Send(buf)
-	}
-
 	c.Set(fiber.HeaderContentType, fiber.MIMETextHTML)
 
 	return c.SendFile(path)
@@ -331,37 +447,29 @@ func (app *App) Render(c fiber.Ctx, url string, vars ...Map) error {
 func (app *App) Error(c fiber.Ctx, status uint16, msg string) error {
 	c.Set(fiber.HeaderContentType, fiber.MIMETextHTML)
 
-	path, err := goutil.JoinPath(app.Config.Root+"/dist", "@"+strconv.FormatUint(uint64(status), 10)+".html")
-	if err != nil {
-		return c.Status(int(status)).SendString("<h1>Error " + strconv.FormatUint(uint64(status), 10) + "</h1><h2>" + msg + "</h2>")
+	// use the error pages of the locale in the url (i.e. /es/...) before the default ones
+	pages := []string{}
+	if locale, _ := app.compiler.pageLocale(strings.Split(strings.Trim(c.Path(), "/"), "/")); locale != app.compiler.defaultLocale() {
+		pages = append(pages, locale+"/@"+strconv.FormatUint(uint64(status), 10)+".html", locale+"/@error.html")
 	}
+	pages = append(pages, "@"+strconv.FormatUint(uint64(status), 10)+".html", "@error.html")
 
-	dynamic := false
-	if _, err := os.Stat(path); err != nil {
-		dynamic = true
-		path, err = goutil.JoinPath(app.Config.Root+"/dist", "@error.html")
-		if err != nil {
-			return c.Status(int(status)).SendString("<h1>Error " + strconv.FormatUint(uint64(status), 10) + "</h1><h2>" + msg + "</h2>")
+	var nodes []*tempNode
+	err := errors.New("error page not found")
+	for _, page := range pages {
+		if nodes, err = app.compiler.dynamicPage(app.Config.Root + "/dist/" + page); err == nil {
+			break
 		}
 	}
-
-	if _, err := os.Stat(path); err != nil {
+	if err != nil {
 		return c.Status(int(status)).SendString("<h1>Error " + strconv.FormatUint(uint64(status), 10) + "</h1><h2>" + msg + "</h2>")
 	}
 
-	if dynamic {
-		buf, err := os.ReadFile(path)
-		if err != nil {
-			return c.Status(int(status)).SendString("<h1>Error " + strconv.FormatUint(uint64(status), 10) + "</h1><h2>" + msg + "</h2>")
-		}
-
-		app.compiler.compileDynamicPage(&buf, Map{
-			"error": strconv.FormatUint(uint64(status), 10),
-			"msg":   msg,
-		})
-
-		return c.Status(int(status)).Send(buf)
-	}
+	c.Status(int(status))
 
-	return c.Status(int(status)).SendFile(path)
+	app.compiler.renderDynamicPage(c, nodes, Map{
+		"error": strconv.FormatUint(uint64(status), 10),
+		"msg":   msg,
+	})
+	return nil
 }
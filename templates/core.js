@@ -9,4 +9,20 @@
     loop();
     setInterval(loop, 1000);
   });
+
+  // copy buttons of code blocks
+  document.addEventListener('click', function(e) {
+    const btn = e.target.closest && e.target.closest('pre.code .code-copy');
+    if(!btn || !navigator.clipboard){
+      return;
+    }
+
+    const code = btn.parentElement.querySelector('code');
+    navigator.clipboard.writeText(code.textContent.replace(/\n$/, '')).then(function() {
+      btn.classList.add('copied');
+      setTimeout(function() {
+        btn.classList.remove('copied');
+      }, 2000);
+    });
+  });
 })();